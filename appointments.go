@@ -0,0 +1,276 @@
+package main
+
+import (
+	"api/data"
+	"api/data/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Functions for manipulating mechanics
+func createMechanicHandler(w http.ResponseWriter, r *http.Request) {
+	var mechanic models.Mechanic
+	if err := json.NewDecoder(r.Body).Decode(&mechanic); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	id, err := data.CreateMechanic(r.Context(), db, mechanic)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	mechanic, err = data.GetMechanic(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeCreated(w, r, fmt.Sprintf("/mechanics/%d", id), mechanic)
+}
+
+func getMechanicHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	mechanic, err := data.GetMechanic(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, mechanic)
+}
+
+func getMechanicsHandler(w http.ResponseWriter, r *http.Request) {
+	mechanics, err := data.GetMechanics(r.Context(), db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, mechanics)
+}
+
+func updateMechanicHandler(w http.ResponseWriter, r *http.Request) {
+	var mechanic models.Mechanic
+	if err := json.NewDecoder(r.Body).Decode(&mechanic); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err := data.UpdateMechanic(r.Context(), db, mechanic)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	mechanic, err = data.GetMechanic(r.Context(), db, mechanic.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, mechanic)
+}
+
+func deleteMechanicHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = data.DeleteMechanic(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeNoContent(w)
+}
+
+// Functions for manipulating service types
+func createServiceTypeHandler(w http.ResponseWriter, r *http.Request) {
+	var serviceType models.ServiceType
+	if err := json.NewDecoder(r.Body).Decode(&serviceType); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	id, err := data.CreateServiceType(r.Context(), db, serviceType)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	serviceType.Id = id
+	writeCreated(w, r, fmt.Sprintf("/servicetypes/%d", id), serviceType)
+}
+
+func getServiceTypeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	serviceType, err := data.GetServiceType(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, serviceType)
+}
+
+func getServiceTypesHandler(w http.ResponseWriter, r *http.Request) {
+	serviceTypes, err := data.GetServiceTypes(r.Context(), db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, serviceTypes)
+}
+
+func updateServiceTypeHandler(w http.ResponseWriter, r *http.Request) {
+	var serviceType models.ServiceType
+	if err := json.NewDecoder(r.Body).Decode(&serviceType); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err := data.UpdateServiceType(r.Context(), db, serviceType)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	serviceType, err = data.GetServiceType(r.Context(), db, serviceType.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, serviceType)
+}
+
+func deleteServiceTypeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = data.DeleteServiceType(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeNoContent(w)
+}
+
+// Functions for booking appointments
+
+// getSlotsHandler lists the free slots for ?service= on ?date= (YYYY-MM-DD)
+func getSlotsHandler(w http.ResponseWriter, r *http.Request) {
+	serviceType, err := strconv.Atoi(r.URL.Query().Get("service"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	day, err := time.ParseInLocation(time.DateOnly, r.URL.Query().Get("date"), time.Local)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	slots, err := data.GetAvailableSlots(r.Context(), db, serviceType, day)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, slots)
+}
+
+func bookAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	var appointment models.Appointment
+	if err := json.NewDecoder(r.Body).Decode(&appointment); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	id, err := data.BookAppointment(r.Context(), db, appointment)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	appointment, err = data.GetAppointment(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeCreated(w, r, fmt.Sprintf("/appointments/%d", id), appointment)
+}
+
+func getAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	appointment, err := data.GetAppointment(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, appointment)
+}
+
+// getAppointmentsHandler lists the appointments on ?date= (YYYY-MM-DD), defaulting to today
+func getAppointmentsHandler(w http.ResponseWriter, r *http.Request) {
+	day := time.Now()
+	if date := r.URL.Query().Get("date"); date != "" {
+		var err error
+		day, err = time.ParseInLocation(time.DateOnly, date, time.Local)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+	appointments, err := data.GetAppointments(r.Context(), db, from, from.AddDate(0, 0, 1))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, appointments)
+}
+
+func cancelAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = data.CancelAppointment(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeNoContent(w)
+}
+
+// dropOffHandler is called when the customer drops the bike off and creates the workcard.
+// The body may contain {"frameNumber": "..."} if the appointment was booked without a bike.
+func dropOffHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var body map[string]string
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	workcard, err := data.DropOffAppointment(r.Context(), db, id, body["frameNumber"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	created, err := data.GetWorkcard(r.Context(), db, workcard)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeCreated(w, r, fmt.Sprintf("/workcards/%d", workcard), created)
+}
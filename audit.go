@@ -0,0 +1,36 @@
+package main
+
+import (
+	"api/data"
+	"api/data/models"
+	"net/http"
+	"strconv"
+)
+
+// getAuditHandler lists the audit trail filtered by ?entity=, ?entityId=, ?user= and ?limit=
+func getAuditHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		Entity:   query.Get("entity"),
+		EntityId: query.Get("entityId"),
+	}
+	var err error
+	if v := query.Get("user"); v != "" {
+		if filter.UserId, err = strconv.Atoi(v); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	entries, err := data.GetAuditEntries(r.Context(), db, filter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, entries)
+}
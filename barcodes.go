@@ -0,0 +1,36 @@
+package main
+
+import (
+	"api/data"
+	"api/validation"
+	"database/sql"
+	"errors"
+	"net/http"
+)
+
+// getProductByBarcodeHandler looks up what a scanner read at the till. A code with a valid GTIN
+// check digit is looked up among the barcodes, and anything else, or a GTIN that is not a known
+// barcode, as a sku. The response holds the product, and the variant when the code is that of a variant.
+func getProductByBarcodeHandler(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+	matchedBy := "barcode"
+	id, err := -1, sql.ErrNoRows
+	if validation.GTIN(code) {
+		id, err = data.ProductIdByBarcode(r.Context(), db, code)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		matchedBy = "sku"
+		id, err = data.ProductIdBySku(r.Context(), db, code)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	scanned, err := data.GetScannedProduct(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	scanned.MatchedBy = matchedBy
+	writeJSON(w, r, http.StatusOK, scanned)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"api/data"
+	"api/data/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Functions for manipulating the category tree and the categories of products
+
+func createCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	var category models.Category
+	if err := json.NewDecoder(r.Body).Decode(&category); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	id, err := data.CreateCategory(r.Context(), db, category)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	category, err = data.GetCategory(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(category.Version))
+	writeCreated(w, r, fmt.Sprintf("/categories/%d", id), category)
+}
+
+// getCategoryHandler returns the category with all of its subcategories
+func getCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	node, err := data.GetCategorySubtree(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTagged(w, r, http.StatusOK, node.Version, node)
+}
+
+// getCategoriesHandler returns the whole category tree
+func getCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	tree, err := data.GetCategoryTree(r.Context(), db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, tree)
+}
+
+func updateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	var category models.Category
+	if err := json.NewDecoder(r.Body).Decode(&category); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before, err := data.GetCategory(r.Context(), db, category.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	category.Version, err = ifMatch(r, before.Version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if _, err := data.UpdateCategory(r.Context(), db, category); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	after, err := data.GetCategory(r.Context(), db, category.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTagged(w, r, http.StatusOK, after.Version, after)
+}
+
+func deleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before, err := data.GetCategory(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r, before.Version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = data.DeleteCategory(r.Context(), db, id, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeNoContent(w)
+}
+
+// getCategoryProductsHandler lists the products in the category and its subcategories,
+// with the filters and sorting of GET /products
+func getCategoryProductsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if _, err := data.GetCategory(r.Context(), db, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q, err := listQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q.Filters["category"] = strconv.Itoa(id)
+	products, err := data.GetProducts(r.Context(), db, q)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, products)
+}
+
+func getProductCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	categories, err := data.GetProductCategories(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, categories)
+}
+
+func addProductCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var categories []int
+	if err := json.NewDecoder(r.Body).Decode(&categories); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = data.AddProductCategories(r.Context(), db, id, categories)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeNoContent(w)
+}
+
+func removeProductCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var categories []int
+	if err := json.NewDecoder(r.Body).Decode(&categories); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = data.RemoveProductCategories(r.Context(), db, id, categories)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeNoContent(w)
+}
+
+// getCategoryReportHandler reports the products, stock and sales per category from ?from=
+// until and including ?to= (YYYY-MM-DD)
+func getCategoryReportHandler(w http.ResponseWriter, r *http.Request) {
+	from, err := time.ParseInLocation(time.DateOnly, r.URL.Query().Get("from"), time.Local)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	to, err := time.ParseInLocation(time.DateOnly, r.URL.Query().Get("to"), time.Local)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	report, err := data.GetCategoryReport(r.Context(), db, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, report)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"api/data"
+	"api/data/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Functions for the costs, stock receipts and margins of products
+
+// getProductCostHandler returns the average cost and the cost prices of the product with their margins
+func getProductCostHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cost, err := data.GetProductCost(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, cost)
+}
+
+// setCostPriceHandler sets what a manufacturer charges for the product, e.g. {"costPrice": 412.50}
+func setCostPriceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	manufacturerId, err := strconv.Atoi(r.PathValue("manufacturerId"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var cost models.ManufacturerCost
+	if err := json.NewDecoder(r.Body).Decode(&cost); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cost.ManufacturerId = manufacturerId
+	if err := data.SetCostPrice(r.Context(), db, id, cost); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	after, err := data.GetProductCost(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	searchIndex.Invalidate()
+	writeJSON(w, r, http.StatusOK, after)
+}
+
+// receiveGoodsHandler adds received items to the stock of the product, e.g.
+// {"manufacturerId": 3, "quantity": 10, "unitCost": 399}. Giving the unit cost instead of
+// using the cost price of the manufacturer needs the costs.write permission.
+func receiveGoodsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var receipt models.GoodsReceipt
+	if err := json.NewDecoder(r.Body).Decode(&receipt); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if receipt.UnitCost != nil {
+		ok, err := can(r, data.PermCostsWrite)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !ok {
+			writeProblem(w, r, http.StatusForbidden, CodeForbidden, "missing permission: "+data.PermCostsWrite)
+			return
+		}
+	}
+	receipt.ProductId = id
+	movement, err := data.ReceiveGoods(r.Context(), db, receipt)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeCreated(w, r, fmt.Sprintf("/products/%d/movements", id), movement)
+}
+
+func getInventoryMovementsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	movements, err := data.GetInventoryMovements(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, movements)
+}
+
+// getMarginReportHandler reports the margin of the bikes sold from ?from= until and including ?to= (YYYY-MM-DD)
+func getMarginReportHandler(w http.ResponseWriter, r *http.Request) {
+	from, err := time.ParseInLocation(time.DateOnly, r.URL.Query().Get("from"), time.Local)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	to, err := time.ParseInLocation(time.DateOnly, r.URL.Query().Get("to"), time.Local)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	report, err := data.GetMarginReport(r.Context(), db, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, report)
+}
@@ -0,0 +1,34 @@
+package data
+
+import "context"
+
+// Actor is who makes the changes done with a context: a logged in user, an API key, or the
+// system for changes not made by a request. Route is the request that makes them.
+type Actor struct {
+	Name     string
+	UserId   *int
+	ApiKeyId *int
+	Route    string
+}
+
+type actorKey struct{}
+
+// WithActor returns a context recording who makes the changes done with it. Every change is
+// audited with the actor, and changes that keep a history, such as price changes, also store
+// its name as their author.
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// currentActor is who makes the changes done with ctx
+func currentActor(ctx context.Context) Actor {
+	if a, ok := ctx.Value(actorKey{}).(Actor); ok {
+		return a
+	}
+	return Actor{Name: "system"}
+}
+
+// actor is the name of who makes the changes done with ctx, or "system" for changes not made by a request
+func actor(ctx context.Context) string {
+	return currentActor(ctx).Name
+}
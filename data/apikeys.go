@@ -0,0 +1,108 @@
+package data
+
+import (
+	"api/data/models"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ApiKeyPrefix starts every API key so it can be told apart from a session token
+const ApiKeyPrefix = "gdk_"
+
+var ErrInvalidApiKey = errors.New("invalid or revoked API key")
+
+// CreateApiKey issues a new key with the given scopes. The returned key is the only
+// time the full key is available, since only its hash is stored.
+func CreateApiKey(ctx context.Context, db DBTX, apiKey models.ApiKey) (models.ApiKey, error) {
+	if apiKey.Scopes == nil {
+		apiKey.Scopes = []string{}
+	}
+	for _, scope := range apiKey.Scopes {
+		if !slices.Contains(Permissions, scope) {
+			return apiKey, invalidf("unknown scope: %s", scope)
+		}
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return apiKey, err
+	}
+	apiKey.Key = ApiKeyPrefix + hex.EncodeToString(b)
+	apiKey.Prefix = apiKey.Key[:len(ApiKeyPrefix)+8]
+
+	// the audit trail gets the key as GetApiKey reads it, which is without the key itself
+	_, err := auditedCreate(ctx, db, "apikey", GetApiKey, func(tx DBTX) (int, error) {
+		err := tx.QueryRowContext(ctx, "INSERT INTO apikeys (name, prefix, keyhash, scopes) VALUES ($1, $2, $3, $4) RETURNING id, created;",
+			apiKey.Name, apiKey.Prefix, hashToken(apiKey.Key), pq.Array(apiKey.Scopes)).Scan(&apiKey.Id, &apiKey.Created)
+		return apiKey.Id, err
+	})
+	return apiKey, err
+}
+
+const apiKeyColumns = "id, name, prefix, scopes, created, lastused, revoked"
+
+func scanApiKey(scanner interface{ Scan(...any) error }, apiKey *models.ApiKey) error {
+	var lastUsed, revoked sql.NullTime
+	err := scanner.Scan(&apiKey.Id, &apiKey.Name, &apiKey.Prefix, pq.Array(&apiKey.Scopes), &apiKey.Created, &lastUsed, &revoked)
+	if err != nil {
+		return err
+	}
+	if lastUsed.Valid {
+		apiKey.LastUsed = &lastUsed.Time
+	}
+	if revoked.Valid {
+		apiKey.Revoked = &revoked.Time
+	}
+	return nil
+}
+
+func GetApiKey(ctx context.Context, db DBTX, id int) (models.ApiKey, error) {
+	var apiKey models.ApiKey
+	err := scanApiKey(db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM apikeys WHERE id = $1", id), &apiKey)
+	return apiKey, err
+}
+
+func GetApiKeys(ctx context.Context, db DBTX) ([]models.ApiKey, error) {
+	var apiKeys []models.ApiKey
+	rows, err := db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM apikeys ORDER BY id")
+	if err != nil {
+		return apiKeys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var apiKey models.ApiKey
+		if err := scanApiKey(rows, &apiKey); err != nil {
+			return apiKeys, err
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+	return apiKeys, nil
+}
+
+func RevokeApiKey(ctx context.Context, db DBTX, id int) error {
+	return auditedUpdate(ctx, db, "apikey", id, GetApiKey, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, "UPDATE apikeys SET revoked = now() WHERE id = $1 AND revoked IS NULL", id)
+		return err
+	})
+}
+
+// UseApiKey looks up a key that has not been revoked and records that it has been used
+func UseApiKey(ctx context.Context, db DBTX, key string) (models.ApiKey, error) {
+	var apiKey models.ApiKey
+	if !strings.HasPrefix(key, ApiKeyPrefix) {
+		return apiKey, ErrInvalidApiKey
+	}
+	row := db.QueryRowContext(ctx, "UPDATE apikeys SET lastused = now() WHERE keyhash = $1 AND revoked IS NULL RETURNING "+apiKeyColumns, hashToken(key))
+	err := scanApiKey(row, &apiKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apiKey, ErrInvalidApiKey
+	}
+	return apiKey, err
+}
@@ -0,0 +1,417 @@
+package data
+
+import (
+	"api/data/models"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrOutsideWorkingHours = errors.New("the appointment is outside the mechanic's working hours")
+	ErrSlotUnavailable     = errors.New("the mechanic is already booked at that time")
+	ErrNotBooked           = errors.New("the appointment is not booked")
+)
+
+// slotStep is the granularity of the slots offered by GetAvailableSlots
+const slotStep = 15 * time.Minute
+
+// Methods for performing CRUD on the mechanics table
+func CreateMechanic(ctx context.Context, db DBTX, mechanic models.Mechanic) (int, error) {
+	id, err := auditedCreate(ctx, db, "mechanic", GetMechanic, func(tx DBTX) (int, error) {
+		var id int
+		err := tx.QueryRowContext(ctx, "INSERT INTO mechanics (name) VALUES ($1) RETURNING id;", mechanic.Name).Scan(&id)
+		if err != nil || mechanic.Hours == nil {
+			return id, err
+		}
+		return id, setMechanicHours(ctx, tx, id, mechanic.Hours)
+	})
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func GetMechanic(ctx context.Context, db DBTX, id int) (models.Mechanic, error) {
+	var mechanic models.Mechanic
+	row := db.QueryRowContext(ctx, "SELECT id, name FROM mechanics WHERE id = $1", id)
+	if row.Err() != nil {
+		return mechanic, row.Err()
+	}
+	err := row.Scan(&mechanic.Id, &mechanic.Name)
+	if err != nil {
+		return mechanic, err
+	}
+	mechanic.Hours, err = getMechanicHours(ctx, db, id)
+	if err != nil {
+		return mechanic, err
+	}
+	return mechanic, nil
+}
+
+func GetMechanics(ctx context.Context, db DBTX) ([]models.Mechanic, error) {
+	var mechanics []models.Mechanic
+	rows, err := db.QueryContext(ctx, "SELECT id, name FROM mechanics ORDER BY id")
+	if err != nil {
+		return mechanics, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mechanic models.Mechanic
+		err := rows.Scan(&mechanic.Id, &mechanic.Name)
+		if err != nil {
+			return mechanics, err
+		}
+		mechanics = append(mechanics, mechanic)
+	}
+	if err := rows.Err(); err != nil {
+		return mechanics, err
+	}
+
+	for i := range mechanics {
+		mechanics[i].Hours, err = getMechanicHours(ctx, db, mechanics[i].Id)
+		if err != nil {
+			return mechanics, err
+		}
+	}
+	return mechanics, nil
+}
+
+func UpdateMechanic(ctx context.Context, db DBTX, mechanic models.Mechanic) error {
+	return auditedUpdate(ctx, db, "mechanic", mechanic.Id, GetMechanic, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, "UPDATE mechanics SET name = $1 WHERE id = $2;", mechanic.Name, mechanic.Id)
+		if err != nil || mechanic.Hours == nil {
+			return err
+		}
+		return setMechanicHours(ctx, tx, mechanic.Id, mechanic.Hours)
+	})
+}
+
+func DeleteMechanic(ctx context.Context, db DBTX, id int) error {
+	return auditedDelete(ctx, db, "mechanic", id, GetMechanic, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM mechanics WHERE id = $1", id)
+		return err
+	})
+}
+
+// setMechanicHours replaces the working hours of a mechanic
+func setMechanicHours(ctx context.Context, tx DBTX, id int, hours []models.WorkingHours) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM mechanichours WHERE mechanicid = $1", id)
+	if err != nil {
+		return err
+	}
+	for _, h := range hours {
+		_, err = tx.ExecContext(ctx, "INSERT INTO mechanichours (mechanicid, weekday, starttime, endtime) VALUES ($1, $2, $3, $4)", id, int(h.Weekday), h.Start, h.End)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getMechanicHours(ctx context.Context, db DBTX, id int) ([]models.WorkingHours, error) {
+	hours := []models.WorkingHours{}
+	rows, err := db.QueryContext(ctx, "SELECT weekday, to_char(starttime, 'HH24:MI'), to_char(endtime, 'HH24:MI') FROM mechanichours WHERE mechanicid = $1 ORDER BY weekday", id)
+	if err != nil {
+		return hours, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h models.WorkingHours
+		err := rows.Scan(&h.Weekday, &h.Start, &h.End)
+		if err != nil {
+			return hours, err
+		}
+		hours = append(hours, h)
+	}
+	return hours, rows.Err()
+}
+
+// Methods for performing CRUD on the servicetypes table
+func CreateServiceType(ctx context.Context, db DBTX, serviceType models.ServiceType) (int, error) {
+	id, err := auditedCreate(ctx, db, "servicetype", GetServiceType, func(tx DBTX) (int, error) {
+		var id int
+		err := tx.QueryRowContext(ctx, "INSERT INTO servicetypes (name, duration) VALUES ($1, $2) RETURNING id;", serviceType.Name, serviceType.Duration).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func GetServiceType(ctx context.Context, db DBTX, id int) (models.ServiceType, error) {
+	var serviceType models.ServiceType
+	row := db.QueryRowContext(ctx, "SELECT id, name, duration FROM servicetypes WHERE id = $1", id)
+	if row.Err() != nil {
+		return serviceType, row.Err()
+	}
+	err := row.Scan(&serviceType.Id, &serviceType.Name, &serviceType.Duration)
+	if err != nil {
+		return serviceType, err
+	}
+	return serviceType, nil
+}
+
+func GetServiceTypes(ctx context.Context, db DBTX) ([]models.ServiceType, error) {
+	var serviceTypes []models.ServiceType
+	rows, err := db.QueryContext(ctx, "SELECT id, name, duration FROM servicetypes ORDER BY id")
+	if err != nil {
+		return serviceTypes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var serviceType models.ServiceType
+		err := rows.Scan(&serviceType.Id, &serviceType.Name, &serviceType.Duration)
+		if err != nil {
+			return serviceTypes, err
+		}
+		serviceTypes = append(serviceTypes, serviceType)
+	}
+	return serviceTypes, nil
+}
+
+func UpdateServiceType(ctx context.Context, db DBTX, serviceType models.ServiceType) error {
+	return auditedUpdate(ctx, db, "servicetype", serviceType.Id, GetServiceType, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, "UPDATE servicetypes SET name = $1, duration = $2 WHERE id = $3;", serviceType.Name, serviceType.Duration, serviceType.Id)
+		return err
+	})
+}
+
+func DeleteServiceType(ctx context.Context, db DBTX, id int) error {
+	return auditedDelete(ctx, db, "servicetype", id, GetServiceType, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM servicetypes WHERE id = $1", id)
+		return err
+	})
+}
+
+// Methods for booking and handling appointments
+
+// BookAppointment books the mechanic for the duration of the service type starting at appointment.Start.
+// The mechanic row is locked while booking so two concurrent bookings cannot take the same slot.
+// ErrOutsideWorkingHours or ErrSlotUnavailable is returned when the slot cannot be booked,
+// and sql.ErrNoRows when there is no such mechanic.
+func BookAppointment(ctx context.Context, db DBTX, appointment models.Appointment) (int, error) {
+	if !appointment.Start.After(time.Now()) {
+		return -1, invalidf("appointments can only be booked in the future")
+	}
+	serviceType, err := GetServiceType(ctx, db, appointment.ServiceTypeId)
+	if err != nil {
+		return -1, err
+	}
+	start := appointment.Start.In(time.Local)
+	end := start.Add(time.Duration(serviceType.Duration) * time.Minute)
+
+	if end.YearDay() != start.YearDay() {
+		return -1, ErrOutsideWorkingHours
+	}
+
+	id, err := auditedCreate(ctx, db, "appointment", GetAppointment, func(tx DBTX) (int, error) {
+		var id int
+		err := tx.QueryRowContext(ctx, "SELECT id FROM mechanics WHERE id = $1 FOR UPDATE", appointment.MechanicId).Scan(&id)
+		if err != nil {
+			return id, err
+		}
+
+		var working bool
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM mechanichours "+
+			"WHERE mechanicid = $1 AND weekday = $2 AND starttime <= $3::time AND endtime >= $4::time)",
+			appointment.MechanicId, int(start.Weekday()), start.Format("15:04:05"), end.Format("15:04:05")).Scan(&working)
+		if err != nil {
+			return id, err
+		}
+		if !working {
+			return id, ErrOutsideWorkingHours
+		}
+
+		var booked bool
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM appointments "+
+			"WHERE mechanicid = $1 AND status <> 'cancelled' AND starttime < $3 AND endtime > $2)",
+			appointment.MechanicId, start, end).Scan(&booked)
+		if err != nil {
+			return id, err
+		}
+		if booked {
+			return id, ErrSlotUnavailable
+		}
+
+		err = tx.QueryRowContext(ctx, "INSERT INTO appointments (mechanicid, servicetypeid, customerid, framenumber, starttime, endtime) "+
+			"VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6) RETURNING id;",
+			appointment.MechanicId, appointment.ServiceTypeId, appointment.CustomerId, appointment.FrameNumber, start, end).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+const appointmentColumns = "id, mechanicid, servicetypeid, customerid, COALESCE(framenumber, ''), starttime, endtime, status, workcardid"
+
+func scanAppointment(scanner interface{ Scan(...any) error }, appointment *models.Appointment) error {
+	var workcard sql.NullInt32
+	err := scanner.Scan(&appointment.Id, &appointment.MechanicId, &appointment.ServiceTypeId, &appointment.CustomerId,
+		&appointment.FrameNumber, &appointment.Start, &appointment.End, &appointment.Status, &workcard)
+	if err != nil {
+		return err
+	}
+	if workcard.Valid {
+		id := int(workcard.Int32)
+		appointment.WorkcardId = &id
+	}
+	return nil
+}
+
+func GetAppointment(ctx context.Context, db DBTX, id int) (models.Appointment, error) {
+	var appointment models.Appointment
+	row := db.QueryRowContext(ctx, "SELECT "+appointmentColumns+" FROM appointments WHERE id = $1", id)
+	if row.Err() != nil {
+		return appointment, row.Err()
+	}
+	err := scanAppointment(row, &appointment)
+	return appointment, err
+}
+
+// GetAppointments returns the appointments starting within [from, to)
+func GetAppointments(ctx context.Context, db DBTX, from time.Time, to time.Time) ([]models.Appointment, error) {
+	var appointments []models.Appointment
+	rows, err := db.QueryContext(ctx, "SELECT "+appointmentColumns+" FROM appointments "+
+		"WHERE starttime >= $1 AND starttime < $2 ORDER BY starttime", from, to)
+	if err != nil {
+		return appointments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var appointment models.Appointment
+		if err := scanAppointment(rows, &appointment); err != nil {
+			return appointments, err
+		}
+		appointments = append(appointments, appointment)
+	}
+	return appointments, nil
+}
+
+func CancelAppointment(ctx context.Context, db DBTX, id int) error {
+	return auditedUpdate(ctx, db, "appointment", id, GetAppointment, func(tx DBTX) error {
+		res, err := tx.ExecContext(ctx, "UPDATE appointments SET status = 'cancelled' WHERE id = $1 AND status = 'booked';", id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotBooked
+		}
+		return nil
+	})
+}
+
+// DropOffAppointment registers that the customer has dropped off the bike and
+// creates a workcard for it, estimated at the duration of the service type. frameNumber is used when the appointment was booked
+// without a bike. The id of the new workcard is returned.
+func DropOffAppointment(ctx context.Context, db DBTX, id int, frameNumber string) (int, error) {
+	var workcard int
+	err := auditedUpdate(ctx, db, "appointment", id, GetAppointment, func(tx DBTX) error {
+		var status string
+		var booked sql.NullString
+		var estimated int
+		err := tx.QueryRowContext(ctx, "SELECT a.status, a.framenumber, s.duration FROM appointments a "+
+			"JOIN servicetypes s ON s.id = a.servicetypeid WHERE a.id = $1 FOR UPDATE OF a", id).Scan(&status, &booked, &estimated)
+		if err != nil {
+			return err
+		}
+		if status != "booked" {
+			return ErrNotBooked
+		}
+		if frameNumber == "" {
+			frameNumber = booked.String
+		}
+		if frameNumber == "" {
+			return invalidf("a frame number is needed to create the workcard")
+		}
+
+		err = tx.QueryRowContext(ctx, "INSERT INTO workcards (status, framenumber, estimatedminutes) VALUES ('Ongoing', $1, $2) RETURNING id;", frameNumber, estimated).Scan(&workcard)
+		if err != nil {
+			return err
+		}
+		created, err := GetWorkcard(ctx, tx, workcard)
+		if err != nil {
+			return err
+		}
+		if err := audit(ctx, tx, "workcard", workcard, nil, created); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE appointments SET status = 'droppedoff', framenumber = $1, workcardid = $2 WHERE id = $3;", frameNumber, workcard, id)
+		return err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return workcard, nil
+}
+
+// GetAvailableSlots returns the free slots on the given day for every mechanic
+// working that day, long enough for the service type.
+func GetAvailableSlots(ctx context.Context, db DBTX, serviceTypeId int, day time.Time) ([]models.Slot, error) {
+	slots := []models.Slot{}
+	serviceType, err := GetServiceType(ctx, db, serviceTypeId)
+	if err != nil {
+		return slots, err
+	}
+	duration := time.Duration(serviceType.Duration) * time.Minute
+
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+	booked, err := GetAppointments(ctx, db, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return slots, err
+	}
+
+	mechanics, err := GetMechanics(ctx, db)
+	if err != nil {
+		return slots, err
+	}
+	now := time.Now()
+	for _, mechanic := range mechanics {
+		for _, hours := range mechanic.Hours {
+			if hours.Weekday != day.Weekday() {
+				continue
+			}
+			opens, err := atTimeOfDay(day, hours.Start)
+			if err != nil {
+				return slots, err
+			}
+			closes, err := atTimeOfDay(day, hours.End)
+			if err != nil {
+				return slots, err
+			}
+			for start := opens; !start.Add(duration).After(closes); start = start.Add(slotStep) {
+				end := start.Add(duration)
+				if start.Before(now) || overlapsBooking(booked, mechanic.Id, start, end) {
+					continue
+				}
+				slots = append(slots, models.Slot{MechanicId: mechanic.Id, Start: start, End: end})
+			}
+		}
+	}
+	return slots, nil
+}
+
+func atTimeOfDay(day time.Time, clock string) (time.Time, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return t, err
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, time.Local), nil
+}
+
+func overlapsBooking(appointments []models.Appointment, mechanicId int, start time.Time, end time.Time) bool {
+	for _, a := range appointments {
+		if a.MechanicId == mechanicId && a.Status != "cancelled" && a.Start.Before(end) && a.End.After(start) {
+			return true
+		}
+	}
+	return false
+}
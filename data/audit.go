@@ -0,0 +1,161 @@
+package data
+
+import (
+	"api/data/models"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// audit records a change to an entity in the audit trail as part of tx, so that the entry is
+// committed or rolled back together with the change. before is nil when the entity was created
+// and after is nil when it was deleted.
+func audit(ctx context.Context, tx DBTX, entity string, id any, before any, after any) error {
+	a := currentActor(ctx)
+	entry := models.AuditEntry{
+		Actor:    a.Name,
+		UserId:   a.UserId,
+		ApiKeyId: a.ApiKeyId,
+		Route:    a.Route,
+		Entity:   entity,
+		EntityId: fmt.Sprint(id),
+	}
+	var err error
+	if entry.Before, err = json.Marshal(before); err != nil {
+		return err
+	}
+	if entry.After, err = json.Marshal(after); err != nil {
+		return err
+	}
+	_, err = CreateAuditEntry(ctx, tx, entry)
+	return err
+}
+
+// auditedCreate creates an entity with create in a transaction, and audits it as get reads it afterwards
+func auditedCreate[K any, T any](ctx context.Context, db DBTX, entity string,
+	get func(context.Context, DBTX, K) (T, error), create func(tx DBTX) (K, error)) (K, error) {
+	var id K
+	err := InTx(ctx, db, func(tx DBTX) error {
+		var err error
+		if id, err = create(tx); err != nil {
+			return err
+		}
+		after, err := get(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		return audit(ctx, tx, entity, id, nil, after)
+	})
+	return id, err
+}
+
+// auditedUpdate changes the entity with change in a transaction, and audits it as get reads it
+// before and after the change
+func auditedUpdate[K any, T any](ctx context.Context, db DBTX, entity string, id K,
+	get func(context.Context, DBTX, K) (T, error), change func(tx DBTX) error) error {
+	return InTx(ctx, db, func(tx DBTX) error {
+		before, err := get(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := change(tx); err != nil {
+			return err
+		}
+		after, err := get(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		return audit(ctx, tx, entity, id, before, after)
+	})
+}
+
+// auditedDelete deletes the entity with del in a transaction, and audits it as get reads it before
+func auditedDelete[K any, T any](ctx context.Context, db DBTX, entity string, id K,
+	get func(context.Context, DBTX, K) (T, error), del func(tx DBTX) error) error {
+	return InTx(ctx, db, func(tx DBTX) error {
+		before, err := get(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := del(tx); err != nil {
+			return err
+		}
+		return audit(ctx, tx, entity, id, before, nil)
+	})
+}
+
+func CreateAuditEntry(ctx context.Context, db DBTX, entry models.AuditEntry) (int, error) {
+	row := db.QueryRowContext(ctx, "INSERT INTO audit (actor, userid, apikeyid, route, entity, entityid, before, after) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id;",
+		entry.Actor, entry.UserId, entry.ApiKeyId, entry.Route, entry.Entity, entry.EntityId, nullJSON(entry.Before), nullJSON(entry.After))
+	if row.Err() != nil {
+		return -1, row.Err()
+	}
+	var id int
+	err := row.Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+// GetAuditEntries returns the newest audit entries matching the filter
+func GetAuditEntries(ctx context.Context, db DBTX, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	entries := []models.AuditEntry{}
+	query := "SELECT id, time, actor, userid, apikeyid, route, entity, entityid, before, after FROM audit WHERE TRUE"
+	var args []any
+	if filter.Entity != "" {
+		args = append(args, filter.Entity)
+		query += " AND entity = $" + strconv.Itoa(len(args))
+	}
+	if filter.EntityId != "" {
+		args = append(args, filter.EntityId)
+		query += " AND entityid = $" + strconv.Itoa(len(args))
+	}
+	if filter.UserId != 0 {
+		args = append(args, filter.UserId)
+		query += " AND userid = $" + strconv.Itoa(len(args))
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = 100
+	}
+	args = append(args, filter.Limit)
+	query += " ORDER BY time DESC, id DESC LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry models.AuditEntry
+		var userId, apiKeyId sql.NullInt32
+		var before, after []byte
+		err := rows.Scan(&entry.Id, &entry.Time, &entry.Actor, &userId, &apiKeyId, &entry.Route, &entry.Entity, &entry.EntityId, &before, &after)
+		if err != nil {
+			return entries, err
+		}
+		if userId.Valid {
+			id := int(userId.Int32)
+			entry.UserId = &id
+		}
+		if apiKeyId.Valid {
+			id := int(apiKeyId.Int32)
+			entry.ApiKeyId = &id
+		}
+		entry.Before, entry.After = before, after
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// nullJSON stores a missing or JSON null value as SQL NULL
+func nullJSON(value []byte) any {
+	if len(value) == 0 || string(value) == "null" {
+		return nil
+	}
+	return string(value)
+}
@@ -0,0 +1,71 @@
+package data
+
+import (
+	"api/data/models"
+	"context"
+)
+
+// setBarcodes replaces the barcodes of the product. A barcode that belongs to another
+// product violates the primary key of barcodes, which is reported as a conflict.
+func setBarcodes(ctx context.Context, tx DBTX, productId int, codes []string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM barcodes WHERE productid = $1", productId)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if seen[gtin14(code)] {
+			return invalidf("barcode %s is listed twice", code)
+		}
+		seen[gtin14(code)] = true
+		_, err := tx.ExecContext(ctx, "INSERT INTO barcodes (gtin, code, productid) VALUES ($1, $2, $3)", gtin14(code), code, productId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gtin14 pads a barcode with zeros to 14 digits, the form it is stored and looked up in
+func gtin14(code string) string {
+	for len(code) < 14 {
+		code = "0" + code
+	}
+	return code
+}
+
+// ProductIdByBarcode returns the id of the product or variant with the barcode. The barcode
+// may be scanned as UPC-A or EAN-13 and still match, as both are padded to the same GTIN.
+func ProductIdByBarcode(ctx context.Context, db DBTX, code string) (int, error) {
+	var id int
+	err := db.QueryRowContext(ctx, "SELECT productid FROM barcodes WHERE gtin = $1", gtin14(code)).Scan(&id)
+	return id, err
+}
+
+// ProductIdBySku returns the id of the product or variant with the sku
+func ProductIdBySku(ctx context.Context, db DBTX, sku string) (int, error) {
+	var id int
+	err := db.QueryRowContext(ctx, "SELECT id FROM products WHERE sku = $1", sku).Scan(&id)
+	return id, err
+}
+
+// GetScannedProduct resolves a product id found by a scan to the product, and to the
+// variant when the id is that of a variant
+func GetScannedProduct(ctx context.Context, db DBTX, id int) (models.ScannedProduct, error) {
+	var scanned models.ScannedProduct
+	product, err := GetProduct(ctx, db, id)
+	if err != nil {
+		return scanned, err
+	}
+	if product.ParentId == nil {
+		scanned.Product = product
+		return scanned, nil
+	}
+	variant, err := GetVariant(ctx, db, *product.ParentId, id)
+	if err != nil {
+		return scanned, err
+	}
+	scanned.Variant = &variant
+	scanned.Product, err = GetProduct(ctx, db, *product.ParentId)
+	return scanned, err
+}
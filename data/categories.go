@@ -0,0 +1,250 @@
+package data
+
+import (
+	"api/data/models"
+	"api/validation"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// categorySubtrees selects the ids of the categories in the comma separated list ? and of all
+// their descendants. A product in a category is also in the categories above it.
+const categorySubtrees = "WITH RECURSIVE subtree AS (" +
+	"SELECT id FROM categories WHERE id = ANY(string_to_array(?, ',')::int[]) " +
+	"UNION SELECT c.id FROM categories c JOIN subtree s ON c.parentid = s.id) " +
+	"SELECT id FROM subtree"
+
+// Methods for CRUD operations on the category tree
+
+func CreateCategory(ctx context.Context, db DBTX, category models.Category) (int, error) {
+	if err := validation.Validate(category); err != nil {
+		return -1, err
+	}
+	id, err := auditedCreate(ctx, db, "category", GetCategory, func(tx DBTX) (int, error) {
+		var id int
+		err := tx.QueryRowContext(ctx, "INSERT INTO categories (name, parentid) VALUES ($1, $2) RETURNING id", category.Name, category.ParentId).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func GetCategory(ctx context.Context, db DBTX, id int) (models.Category, error) {
+	var category models.Category
+	err := db.QueryRowContext(ctx, "SELECT id, name, parentid, version FROM categories WHERE id = $1", id).
+		Scan(&category.Id, &category.Name, &category.ParentId, &category.Version)
+	return category, err
+}
+
+// GetCategoryTree returns the categories without a parent with their subcategories, by name
+func GetCategoryTree(ctx context.Context, db DBTX) ([]models.CategoryNode, error) {
+	children, err := categoryChildren(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	return categoryNodes(children, 0), nil
+}
+
+// GetCategorySubtree returns the category with its subcategories
+func GetCategorySubtree(ctx context.Context, db DBTX, id int) (models.CategoryNode, error) {
+	var node models.CategoryNode
+	category, err := GetCategory(ctx, db, id)
+	if err != nil {
+		return node, err
+	}
+	children, err := categoryChildren(ctx, db)
+	if err != nil {
+		return node, err
+	}
+	return models.CategoryNode{Category: category, Children: categoryNodes(children, id)}, nil
+}
+
+// categoryChildren maps the id of every category to its subcategories, with 0 for the top of the tree
+func categoryChildren(ctx context.Context, db DBTX) (map[int][]models.Category, error) {
+	children := map[int][]models.Category{}
+	rows, err := db.QueryContext(ctx, "SELECT id, name, parentid, version FROM categories ORDER BY lower(name), id")
+	if err != nil {
+		return children, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var category models.Category
+		if err := rows.Scan(&category.Id, &category.Name, &category.ParentId, &category.Version); err != nil {
+			return children, err
+		}
+		parent := 0
+		if category.ParentId != nil {
+			parent = *category.ParentId
+		}
+		children[parent] = append(children[parent], category)
+	}
+	return children, rows.Err()
+}
+
+func categoryNodes(children map[int][]models.Category, parent int) []models.CategoryNode {
+	nodes := []models.CategoryNode{}
+	for _, category := range children[parent] {
+		nodes = append(nodes, models.CategoryNode{Category: category, Children: categoryNodes(children, category.Id)})
+	}
+	return nodes
+}
+
+// UpdateCategory renames or moves the category if it is still at category.Version, and returns its new version.
+// A category cannot be moved below itself or one of its subcategories.
+func UpdateCategory(ctx context.Context, db DBTX, category models.Category) (int, error) {
+	if err := validation.Validate(category); err != nil {
+		return -1, err
+	}
+	var version int
+	err := auditedUpdate(ctx, db, "category", category.Id, GetCategory, func(tx DBTX) error {
+		if category.ParentId != nil {
+			// two moves checked at the same time could still make a loop, so moves wait for each other
+			if _, err := tx.ExecContext(ctx, "LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+				return err
+			}
+			var loop bool
+			err := tx.QueryRowContext(ctx, "WITH RECURSIVE ancestors AS ("+
+				"SELECT id, parentid FROM categories WHERE id = $1 "+
+				"UNION SELECT c.id, c.parentid FROM categories c JOIN ancestors a ON c.id = a.parentid) "+
+				"SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)", *category.ParentId, category.Id).Scan(&loop)
+			if err != nil {
+				return err
+			}
+			if loop {
+				return invalidf("category %d cannot be moved below itself or its subcategories", category.Id)
+			}
+		}
+		err := tx.QueryRowContext(ctx, "UPDATE categories SET name = $1, parentid = $2, version = version + 1 "+
+			"WHERE id = $3 AND version = $4 RETURNING version",
+			category.Name, category.ParentId, category.Id, category.Version).Scan(&version)
+		if errors.Is(err, sql.ErrNoRows) {
+			return versionConflict(ctx, tx, "categories", "id", category.Id)
+		}
+		return err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return version, nil
+}
+
+// DeleteCategory deletes the category if it is still at the given version. Its products stay
+// in the catalogue, but a category with subcategories cannot be deleted.
+func DeleteCategory(ctx context.Context, db DBTX, id int, version int) error {
+	return auditedDelete(ctx, db, "category", id, GetCategory, func(tx DBTX) error {
+		return versionedExec(ctx, tx, "categories", "id", id, "DELETE FROM categories WHERE id = $1 AND version = $2", id, version)
+	})
+}
+
+// GetProductCategories returns the categories the product has been put in, or
+// sql.ErrNoRows when there is no such product
+func GetProductCategories(ctx context.Context, db DBTX, id int) ([]models.Category, error) {
+	categories := []models.Category{}
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)", id).Scan(&exists)
+	if err != nil {
+		return categories, err
+	}
+	if !exists {
+		return categories, sql.ErrNoRows
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT c.id, c.name, c.parentid, c.version FROM categories c "+
+		"JOIN productscategories pc ON pc.categoryid = c.id WHERE pc.productid = $1 ORDER BY lower(c.name), c.id", id)
+	if err != nil {
+		return categories, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var category models.Category
+		if err := rows.Scan(&category.Id, &category.Name, &category.ParentId, &category.Version); err != nil {
+			return categories, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+// AddProductCategories puts the product in the categories. Either it is put in all of them or none.
+// Categories the product is already in are left as they are. Variants are in the categories of
+// the product they are a variant of.
+func AddProductCategories(ctx context.Context, db DBTX, id int, categories []int) error {
+	return InTx(ctx, db, func(tx DBTX) error {
+		var parent sql.NullInt32
+		err := tx.QueryRowContext(ctx, "SELECT parentid FROM products WHERE id = $1", id).Scan(&parent)
+		if err != nil {
+			return err
+		}
+		if parent.Valid {
+			return invalidf("product %d is a variant and is in the categories of product %d", id, parent.Int32)
+		}
+		var added []int
+		for _, category := range categories {
+			res, err := tx.ExecContext(ctx, "INSERT INTO productscategories (productid, categoryid) VALUES ($1, $2) ON CONFLICT DO NOTHING", id, category)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				added = append(added, category)
+			}
+		}
+		if len(added) == 0 {
+			return nil
+		}
+		return audit(ctx, tx, "productcategories", id, nil, added)
+	})
+}
+
+// RemoveProductCategories takes the product out of the categories. Either it is taken out of all of them or none.
+func RemoveProductCategories(ctx context.Context, db DBTX, id int, categories []int) error {
+	return InTx(ctx, db, func(tx DBTX) error {
+		for _, category := range categories {
+			_, err := tx.ExecContext(ctx, "DELETE FROM productscategories WHERE productid = $1 AND categoryid = $2", id, category)
+			if err != nil {
+				return err
+			}
+		}
+		return audit(ctx, tx, "productcategories", id, categories, nil)
+	})
+}
+
+// GetCategoryReport sums up every category with its subcategories: the products in it, their stock
+// and its value at the current prices, and the bikes sold within [from, to) and their price.
+// A product in several categories of a subtree is only counted once for it.
+func GetCategoryReport(ctx context.Context, db DBTX, from time.Time, to time.Time) ([]models.CategoryReport, error) {
+	report := []models.CategoryReport{}
+	rows, err := db.QueryContext(ctx, "WITH RECURSIVE tree AS ("+
+		"SELECT id AS root, id FROM categories "+
+		"UNION SELECT t.root, c.id FROM categories c JOIN tree t ON c.parentid = t.id), "+
+		"members AS ("+
+		"SELECT DISTINCT t.root, p.id, p.parentid, p.price, p.stock FROM tree t "+
+		"JOIN productscategories pc ON pc.categoryid = t.id "+
+		"JOIN products p ON COALESCE(p.parentid, p.id) = pc.productid) "+
+		"SELECT c.id, c.name, c.parentid, s.products, s.stock, s.value, b.sold, b.revenue FROM categories c "+
+		"CROSS JOIN LATERAL (SELECT COUNT(*) FILTER (WHERE m.parentid IS NULL), COALESCE(SUM(m.stock), 0), COALESCE(SUM(m.stock * m.price), 0) "+
+		"FROM members m WHERE m.root = c.id) s (products, stock, value) "+
+		"CROSS JOIN LATERAL (SELECT COUNT(*), COALESCE(SUM(m.price), 0) "+
+		"FROM bikes JOIN members m ON m.id = bikes.productid "+
+		"WHERE m.root = c.id AND bikes.soldat >= $1 AND bikes.soldat < $2) b (sold, revenue) "+
+		"ORDER BY c.id", from, to)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.CategoryReport
+		err := rows.Scan(&c.CategoryId, &c.Name, &c.ParentId, &c.Products, &c.Stock, &c.StockValue, &c.BikesSold, &c.Revenue)
+		if err != nil {
+			return report, err
+		}
+		report = append(report, c)
+	}
+	return report, rows.Err()
+}
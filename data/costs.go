@@ -0,0 +1,217 @@
+package data
+
+import (
+	"api/data/models"
+	"api/validation"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// margin computes what is earned selling at price what cost cost
+func margin(price float32, cost *float32) models.Margin {
+	var m models.Margin
+	if cost == nil {
+		return m
+	}
+	profit := price - *cost
+	m.Profit = &profit
+	if price != 0 {
+		rate := profit / price
+		m.Rate = &rate
+	}
+	if *cost != 0 {
+		markup := profit / *cost
+		m.Markup = &markup
+	}
+	return m
+}
+
+// GetProductCost returns what the product costs and earns at its current price
+func GetProductCost(ctx context.Context, db DBTX, id int) (models.ProductCost, error) {
+	cost := models.ProductCost{ProductId: id, Manufacturers: []models.ManufacturerCost{}}
+	err := db.QueryRowContext(ctx, "SELECT price, averagecost FROM products WHERE id = $1", id).Scan(&cost.Price, &cost.AverageCost)
+	if err != nil {
+		return cost, err
+	}
+	cost.Margin = margin(cost.Price, cost.AverageCost)
+
+	rows, err := db.QueryContext(ctx, "SELECT m.id, m.name, pm.costprice FROM productsmanufacturers pm "+
+		"JOIN manufacturers m ON m.id = pm.manufacturerid WHERE pm.productid = $1 ORDER BY m.id", id)
+	if err != nil {
+		return cost, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m models.ManufacturerCost
+		if err := rows.Scan(&m.ManufacturerId, &m.Name, &m.CostPrice); err != nil {
+			return cost, err
+		}
+		m.Margin = margin(cost.Price, m.CostPrice)
+		cost.Manufacturers = append(cost.Manufacturers, m)
+	}
+	return cost, rows.Err()
+}
+
+// SetCostPrice sets what the manufacturer charges for the product, linking them if they were not yet
+func SetCostPrice(ctx context.Context, db DBTX, productId int, cost models.ManufacturerCost) error {
+	if err := validation.Validate(cost); err != nil {
+		return err
+	}
+	return auditedUpdate(ctx, db, "productcost", productId, GetProductCost, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO productsmanufacturers (productid, manufacturerid, costprice) VALUES ($1, $2, $3) "+
+			"ON CONFLICT (productid, manufacturerid) DO UPDATE SET costprice = EXCLUDED.costprice", productId, cost.ManufacturerId, cost.CostPrice)
+		return err
+	})
+}
+
+const movementColumns = "id, productid, manufacturerid, purchaseorderlineid, quantity, unitcost, averagecost, reason, author, created"
+
+func scanMovement(scanner interface{ Scan(...any) error }, movement *models.InventoryMovement) error {
+	return scanner.Scan(&movement.Id, &movement.ProductId, &movement.ManufacturerId, &movement.PurchaseOrderLineId, &movement.Quantity,
+		&movement.UnitCost, &movement.AverageCost, &movement.Reason, &movement.Author, &movement.Created)
+}
+
+// ReceiveGoods adds the received items to the stock of the product and updates its weighted average
+// cost: the cost of the stock and of the received items together, divided by the number of items.
+// The product has to be linked to the manufacturer, whose cost price is used when the receipt has no unit cost.
+func ReceiveGoods(ctx context.Context, db DBTX, receipt models.GoodsReceipt) (models.InventoryMovement, error) {
+	var movement models.InventoryMovement
+	if err := validation.Validate(receipt); err != nil {
+		return movement, err
+	}
+	err := InTx(ctx, db, func(tx DBTX) error {
+		var err error
+		movement, err = receiveGoods(ctx, tx, receipt, nil)
+		return err
+	})
+	return movement, err
+}
+
+// receiveGoods posts the receipt as an inventory movement, for the line of a purchase order when line is set
+func receiveGoods(ctx context.Context, tx DBTX, receipt models.GoodsReceipt, line *int) (models.InventoryMovement, error) {
+	var movement models.InventoryMovement
+	var costPrice *float32
+	err := tx.QueryRowContext(ctx, "SELECT costprice FROM productsmanufacturers WHERE productid = $1 AND manufacturerid = $2",
+		receipt.ProductId, receipt.ManufacturerId).Scan(&costPrice)
+	if errors.Is(err, sql.ErrNoRows) {
+		return movement, invalidf("product %d is not supplied by manufacturer %d", receipt.ProductId, receipt.ManufacturerId)
+	}
+	if err != nil {
+		return movement, err
+	}
+	unitCost := receipt.UnitCost
+	if unitCost == nil {
+		unitCost = costPrice
+	}
+	if unitCost == nil {
+		return movement, invalidf("the receipt has no unit cost, and manufacturer %d has no cost price for product %d", receipt.ManufacturerId, receipt.ProductId)
+	}
+
+	// stock on the right hand side is the stock before the receipt. Stock that is missing
+	// or has no known cost does not count towards the average.
+	var averageCost float32
+	err = tx.QueryRowContext(ctx, "UPDATE products SET stock = stock + $1, "+
+		"averagecost = CASE WHEN averagecost IS NULL OR stock <= 0 THEN $2 ELSE (stock * averagecost + $1 * $2) / (stock + $1) END, "+
+		"version = version + 1 WHERE id = $3 RETURNING averagecost",
+		receipt.Quantity, *unitCost, receipt.ProductId).Scan(&averageCost)
+	if err != nil {
+		return movement, err
+	}
+	row := tx.QueryRowContext(ctx, "INSERT INTO inventorymovements (productid, manufacturerid, purchaseorderlineid, quantity, unitcost, averagecost, reason, author) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, 'receipt', $7) RETURNING "+movementColumns,
+		receipt.ProductId, receipt.ManufacturerId, line, receipt.Quantity, *unitCost, averageCost, actor(ctx))
+	if err := scanMovement(row, &movement); err != nil {
+		return movement, err
+	}
+	return movement, audit(ctx, tx, "inventorymovement", movement.Id, nil, movement)
+}
+
+// adjustStock posts a change of stock that is not a receipt, such as a stock count, as an
+// adjustment movement. The average cost is kept: items counted in or out are valued at it.
+func adjustStock(ctx context.Context, tx DBTX, productId int, quantity int) error {
+	if quantity == 0 {
+		return nil
+	}
+	var movement models.InventoryMovement
+	row := tx.QueryRowContext(ctx, "INSERT INTO inventorymovements (productid, quantity, averagecost, reason, author) "+
+		"SELECT id, $2, averagecost, 'adjustment', $3 FROM products WHERE id = $1 RETURNING "+movementColumns,
+		productId, quantity, actor(ctx))
+	if err := scanMovement(row, &movement); err != nil {
+		return err
+	}
+	return audit(ctx, tx, "inventorymovement", movement.Id, nil, movement)
+}
+
+// GetInventoryMovements returns the stock movements of the product, the latest first,
+// or sql.ErrNoRows when there is no such product
+func GetInventoryMovements(ctx context.Context, db DBTX, productId int) ([]models.InventoryMovement, error) {
+	movements := []models.InventoryMovement{}
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)", productId).Scan(&exists)
+	if err != nil {
+		return movements, err
+	}
+	if !exists {
+		return movements, sql.ErrNoRows
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT "+movementColumns+" FROM inventorymovements WHERE productid = $1 ORDER BY created DESC, id DESC", productId)
+	if err != nil {
+		return movements, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movement models.InventoryMovement
+		if err := scanMovement(rows, &movement); err != nil {
+			return movements, err
+		}
+		movements = append(movements, movement)
+	}
+	return movements, rows.Err()
+}
+
+// GetMarginReport reports the margin of every bike sold within [from, to). A sale is valued at the
+// price of the product when it was sold, from its price history, and at its average cost after the
+// last goods receipt before the sale. Sales from before the first receipt use the current average cost.
+func GetMarginReport(ctx context.Context, db DBTX, from time.Time, to time.Time) (models.MarginReport, error) {
+	report := models.MarginReport{Sales: []models.SaleMargin{}}
+	rows, err := db.QueryContext(ctx, "SELECT b.framenumber, p.id, p.name, b.soldat, "+
+		"COALESCE((SELECT pc.price FROM pricechanges pc WHERE pc.productid = p.id AND pc.applied AND pc.effective <= b.soldat "+
+		"ORDER BY pc.effective DESC, pc.id DESC LIMIT 1), p.price), "+
+		"COALESCE((SELECT m.averagecost FROM inventorymovements m WHERE m.productid = p.id AND m.created <= b.soldat "+
+		"ORDER BY m.created DESC, m.id DESC LIMIT 1), p.averagecost) "+
+		"FROM bikes b JOIN products p ON p.id = b.productid "+
+		"WHERE b.soldat >= $1 AND b.soldat < $2 ORDER BY b.soldat, b.framenumber", from, to)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sale models.SaleMargin
+		if err := rows.Scan(&sale.FrameNumber, &sale.ProductId, &sale.Name, &sale.SoldAt, &sale.Price, &sale.Cost); err != nil {
+			return report, err
+		}
+		sale.Margin = margin(sale.Price, sale.Cost)
+		if sale.Cost == nil {
+			report.Unknown++
+		} else {
+			report.Revenue += float64(sale.Price)
+			report.Cost += float64(*sale.Cost)
+		}
+		report.Sales = append(report.Sales, sale)
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	report.Profit = report.Revenue - report.Cost
+	if report.Revenue != 0 {
+		rate := report.Profit / report.Revenue
+		report.Margin = &rate
+	}
+	return report, nil
+}
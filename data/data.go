@@ -2,8 +2,13 @@ package data
 
 import (
 	"api/data/models"
+	"api/validation"
+	"context"
 	"database/sql"
+	"errors"
 	"log"
+
+	"github.com/lib/pq"
 )
 
 // SetupDB Method for setting up the tables
@@ -47,6 +52,50 @@ func SetupDB(db *sql.DB) {
 		log.Fatal(err)
 	}
 
+	_, err = db.Exec("ALTER TABLE bikes ADD COLUMN IF NOT EXISTS soldAt TIMESTAMPTZ;")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// bikes that had an owner before soldAt was recorded were sold at an unknown date. They count
+	// as sold when the column was backfilled, so that their sale reminders are scheduled from then
+	// on instead of never, or all at once.
+	_, err = db.Exec("UPDATE bikes SET soldAt = now() WHERE owner IS NOT NULL AND soldAt IS NULL;")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// variants are products with a parent, whose name they share. Their price is the parent's
+	// price unless they have a price override, and is kept up to date when the parent changes.
+	_, err = db.Exec("ALTER TABLE products " +
+		"ADD COLUMN IF NOT EXISTS parentID INT REFERENCES products(id) ON DELETE CASCADE," +
+		"ADD COLUMN IF NOT EXISTS sku VARCHAR(64) UNIQUE," +
+		"ADD COLUMN IF NOT EXISTS frameSize VARCHAR(255)," +
+		"ADD COLUMN IF NOT EXISTS wheelSize VARCHAR(255)," +
+		"ADD COLUMN IF NOT EXISTS priceOverride FLOAT," +
+		"ADD COLUMN IF NOT EXISTS stock INT NOT NULL DEFAULT 0;")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS products_parent ON products (parentID);")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// gtin is the barcode padded to 14 digits, so that the UPC-A and EAN-13 forms of a barcode are the same
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS barcodes (" +
+		"gtin CHAR(14) PRIMARY KEY," +
+		"code VARCHAR(14) NOT NULL," +
+		"productID INT references products(id) ON DELETE CASCADE NOT NULL);")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS barcodes_product ON barcodes (productID);")
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	_, err = db.Exec("CREATE TABLE IF NOT EXISTS productsmanufacturers (" +
 		"productID INT references products(id) NOT NULL," +
 		"manufacturerID INT references manufacturers(id) NOT NULL," +
@@ -54,158 +103,497 @@ func SetupDB(db *sql.DB) {
 	if err != nil {
 		log.Fatal(err)
 	}
-}
 
-// Methods for CRUD operations on the products table
-func CreateProduct(db *sql.DB, product *models.Product) (int, error) {
-	row := db.QueryRow("INSERT INTO products(name, price, size, color) VALUES ($1, $2, $3, $4) RETURNING id", product.Name, product.Price, product.Size, product.Color)
-	if row.Err() != nil {
-		return -1, row.Err()
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS workcards (" +
+		"ID SERIAL PRIMARY KEY," +
+		"status VARCHAR(255) NOT NULL," +
+		"frameNumber VARCHAR(255) references bikes(frameNumber) ON DELETE CASCADE NOT NULL," +
+		"created TIMESTAMPTZ NOT NULL DEFAULT now());")
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	var id int
-	err := row.Scan(&id)
+	_, err = db.Exec("ALTER TABLE workcards ADD COLUMN IF NOT EXISTS estimatedMinutes INT NOT NULL DEFAULT 0;")
 	if err != nil {
-		return -1, err
+		log.Fatal(err)
 	}
-	return id, nil
-}
 
-func GetProduct(db *sql.DB, id int) (models.Product, error) {
-	var product models.Product
-	row := db.QueryRow("SELECT * FROM products WHERE id = $1", id)
-	if row.Err() != nil {
-		return product, row.Err()
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS reminderrules (" +
+		"ID SERIAL PRIMARY KEY," +
+		"name VARCHAR(255) NOT NULL," +
+		"trigger VARCHAR(255) NOT NULL CHECK (trigger IN ('sale', 'workcard'))," +
+		"months INT NOT NULL CHECK (months > 0)," +
+		"subject VARCHAR(255) NOT NULL," +
+		"body TEXT NOT NULL," +
+		"active BOOLEAN NOT NULL DEFAULT TRUE);")
+	if err != nil {
+		log.Fatal(err)
 	}
-	err := row.Scan(&product.Id, &product.Name, &product.Price, &product.Size, &product.Color)
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS reminders (" +
+		"ID SERIAL PRIMARY KEY," +
+		"ruleID INT references reminderrules(id) ON DELETE CASCADE NOT NULL," +
+		"frameNumber VARCHAR(255) references bikes(frameNumber) ON DELETE CASCADE NOT NULL," +
+		"customerID INT references customers(id) ON DELETE CASCADE NOT NULL," +
+		"email VARCHAR(255) NOT NULL," +
+		"sentAt TIMESTAMPTZ NOT NULL DEFAULT now());")
 	if err != nil {
-		return product, err
+		log.Fatal(err)
 	}
-	return product, nil
-}
 
-func GetProducts(db *sql.DB) ([]models.Product, error) {
-	var products []models.Product
-	rows, err := db.Query("SELECT * FROM products")
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS mechanics (" +
+		"ID SERIAL PRIMARY KEY," +
+		"name VARCHAR(255) NOT NULL);")
 	if err != nil {
-		return products, err
+		log.Fatal(err)
 	}
-	defer func(rows *sql.Rows) {
-		err := rows.Close()
-		if err != nil {
 
-		}
-	}(rows)
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS mechanichours (" +
+		"mechanicID INT references mechanics(id) ON DELETE CASCADE NOT NULL," +
+		"weekday INT NOT NULL CHECK (weekday BETWEEN 0 AND 6)," +
+		"startTime TIME NOT NULL," +
+		"endTime TIME NOT NULL CHECK (endTime > startTime)," +
+		"PRIMARY KEY (mechanicID, weekday));")
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	for rows.Next() {
-		var product models.Product
-		err := rows.Scan(&product.Id, &product.Name, &product.Price, &product.Size, &product.Color)
-		if err != nil {
-			return products, err
-		}
-		products = append(products, product)
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS servicetypes (" +
+		"ID SERIAL PRIMARY KEY," +
+		"name VARCHAR(255) NOT NULL," +
+		"duration INT NOT NULL CHECK (duration > 0));")
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	return products, nil
-}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS appointments (" +
+		"ID SERIAL PRIMARY KEY," +
+		"mechanicID INT references mechanics(id) NOT NULL," +
+		"serviceTypeID INT references servicetypes(id) NOT NULL," +
+		"customerID INT references customers(id) NOT NULL," +
+		"frameNumber VARCHAR(255) references bikes(frameNumber)," +
+		"startTime TIMESTAMPTZ NOT NULL," +
+		"endTime TIMESTAMPTZ NOT NULL," +
+		"status VARCHAR(255) NOT NULL DEFAULT 'booked'," +
+		"workcardID INT references workcards(id));")
+	if err != nil {
+		log.Fatal(err)
+	}
 
-func GetProductsBySize(db *sql.DB, size string) ([]models.Product, error) {
-	var products []models.Product
-	rows, err := db.Query("SELECT * FROM products WHERE size = $1", size)
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS timeentries (" +
+		"ID SERIAL PRIMARY KEY," +
+		"workcardID INT references workcards(id) ON DELETE CASCADE NOT NULL," +
+		"mechanicID INT references mechanics(id) NOT NULL," +
+		"clockIn TIMESTAMPTZ NOT NULL DEFAULT now()," +
+		"clockOut TIMESTAMPTZ CHECK (clockOut >= clockIn));")
 	if err != nil {
-		return products, err
+		log.Fatal(err)
 	}
-	for rows.Next() {
-		var product models.Product
-		err = rows.Scan(&product.Id, &product.Name, &product.Price, &product.Size, &product.Color)
-		if err != nil {
-			return products, err
-		}
-		products = append(products, product)
+
+	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS timeentries_open ON timeentries (mechanicID) WHERE clockOut IS NULL;")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS users (" +
+		"ID SERIAL PRIMARY KEY," +
+		"username VARCHAR(255) NOT NULL UNIQUE," +
+		"name VARCHAR(255) NOT NULL," +
+		"passwordHash VARCHAR(255) NOT NULL);")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Users created before roles existed could do everything, so they keep doing so as owners
+	_, err = db.Exec("ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(255) NOT NULL DEFAULT 'owner';")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS rolepermissions (" +
+		"role VARCHAR(255) NOT NULL," +
+		"permission VARCHAR(255) NOT NULL," +
+		"PRIMARY KEY (role, permission));")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = seedRolePermissions(context.Background(), db)
+	if err != nil {
+		log.Fatal(err)
 	}
-	return products, nil
-}
 
-func GetProductsByColor(db *sql.DB, color string) ([]models.Product, error) {
-	var products []models.Product
-	rows, err := db.Query("SELECT * FROM products WHERE color = $1;", color)
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS apikeys (" +
+		"ID SERIAL PRIMARY KEY," +
+		"name VARCHAR(255) NOT NULL," +
+		"prefix VARCHAR(255) NOT NULL," +
+		"keyHash VARCHAR(64) NOT NULL UNIQUE," +
+		"scopes TEXT[] NOT NULL," +
+		"created TIMESTAMPTZ NOT NULL DEFAULT now()," +
+		"lastUsed TIMESTAMPTZ," +
+		"revoked TIMESTAMPTZ);")
 	if err != nil {
-		return products, nil
+		log.Fatal(err)
 	}
-	for rows.Next() {
-		var product models.Product
-		err = rows.Scan(&product.Id, &product.Name, &product.Price, &product.Size, &product.Color)
+
+	// permissionupgrades records the split permissions that have been granted, so a permission
+	// that is taken away again is not granted anew on the next start
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS permissionupgrades (" +
+		"permission VARCHAR(255) NOT NULL," +
+		"fromPermission VARCHAR(255) NOT NULL," +
+		"upgraded TIMESTAMPTZ NOT NULL DEFAULT now()," +
+		"PRIMARY KEY (permission, fromPermission));")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = upgradePermissions(context.Background(), db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS audit (" +
+		"ID SERIAL PRIMARY KEY," +
+		"time TIMESTAMPTZ NOT NULL DEFAULT now()," +
+		"actor VARCHAR(255) NOT NULL," +
+		"userID INT," +
+		"apiKeyID INT," +
+		"route VARCHAR(255) NOT NULL," +
+		"entity VARCHAR(255) NOT NULL," +
+		"entityID VARCHAR(255) NOT NULL," +
+		"before JSONB," +
+		"after JSONB);")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS audit_entity ON audit (entity, entityID);")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, table := range []string{"products", "customers", "manufacturers", "bikes"} {
+		_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;")
 		if err != nil {
-			return products, err
+			log.Fatal(err)
 		}
-		products = append(products, product)
 	}
-	return products, nil
-}
 
-func GetProductsByName(db *sql.DB, name string) ([]models.Product, error) {
-	var products []models.Product
-	var paddedName = "%" + name + "%"
-	rows, err := db.Query("SELECT * FROM products WHERE name ILIKE $1", paddedName)
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS idempotencykeys (" +
+		"owner VARCHAR(255) NOT NULL," +
+		"key VARCHAR(255) NOT NULL," +
+		"requestHash VARCHAR(64) NOT NULL," +
+		"status INT," +
+		"headers JSONB," +
+		"body BYTEA," +
+		"created TIMESTAMPTZ NOT NULL DEFAULT now()," +
+		"PRIMARY KEY (owner, key));")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idempotencykeys_created ON idempotencykeys (created);")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// locked is when a request last claimed the key, so that a claim left by a crash runs out
+	_, err = db.Exec("ALTER TABLE idempotencykeys ADD COLUMN IF NOT EXISTS locked TIMESTAMPTZ NOT NULL DEFAULT now();")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS sessions (" +
+		"tokenHash VARCHAR(64) PRIMARY KEY," +
+		"userID INT references users(id) ON DELETE CASCADE NOT NULL," +
+		"created TIMESTAMPTZ NOT NULL DEFAULT now()," +
+		"expires TIMESTAMPTZ NOT NULL);")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// categories form a tree. A category with subcategories cannot be deleted, so that
+	// subcategories are never left without their parent.
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS categories (" +
+		"ID SERIAL PRIMARY KEY," +
+		"name VARCHAR(255) NOT NULL," +
+		"parentID INT references categories(id)," +
+		"version INT NOT NULL DEFAULT 1);")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS categories_name ON categories (COALESCE(parentID, 0), lower(name));")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS productscategories (" +
+		"productID INT references products(id) ON DELETE CASCADE NOT NULL," +
+		"categoryID INT references categories(id) ON DELETE CASCADE NOT NULL," +
+		"PRIMARY KEY (productID, categoryID));")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS productscategories_category ON productscategories (categoryID);")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// pricechanges is the price history of the products. Changes made to a product are applied
+	// at once, and scheduled changes are applied when they take effect.
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS pricechanges (" +
+		"ID SERIAL PRIMARY KEY," +
+		"productID INT references products(id) ON DELETE CASCADE NOT NULL," +
+		"price FLOAT NOT NULL," +
+		"effective TIMESTAMPTZ NOT NULL," +
+		"author VARCHAR(255) NOT NULL," +
+		"created TIMESTAMPTZ NOT NULL DEFAULT now()," +
+		"applied BOOLEAN NOT NULL DEFAULT false);")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS pricechanges_product ON pricechanges (productID, effective);")
 	if err != nil {
-		return products, err
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS pricechanges_due ON pricechanges (effective) WHERE NOT applied;")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// costPrice is what the manufacturer charges for the product, and averageCost is the
+	// weighted average of what the stock of the product has cost
+	_, err = db.Exec("ALTER TABLE productsmanufacturers ADD COLUMN IF NOT EXISTS costPrice FLOAT;")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("ALTER TABLE products ADD COLUMN IF NOT EXISTS averageCost FLOAT;")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// inventorymovements is the stock ledger. averageCost is the average cost of the product
+	// after the movement, so that the cost of a past sale can be found.
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS inventorymovements (" +
+		"ID SERIAL PRIMARY KEY," +
+		"productID INT references products(id) ON DELETE CASCADE NOT NULL," +
+		"manufacturerID INT references manufacturers(id)," +
+		"quantity INT NOT NULL CHECK (quantity <> 0)," +
+		"unitCost FLOAT," +
+		"averageCost FLOAT," +
+		"reason VARCHAR(255) NOT NULL," +
+		"author VARCHAR(255) NOT NULL," +
+		"created TIMESTAMPTZ NOT NULL DEFAULT now());")
+	if err != nil {
+		log.Fatal(err)
 	}
-	for rows.Next() {
-		var product models.Product
-		err = rows.Scan(&product.Id, &product.Name, &product.Price, &product.Size, &product.Color)
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS inventorymovements_product ON inventorymovements (productID, created);")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS purchaseorders (" +
+		"ID SERIAL PRIMARY KEY," +
+		"manufacturerID INT references manufacturers(id) NOT NULL," +
+		"status VARCHAR(255) NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'sent', 'partiallyreceived', 'received', 'cancelled'))," +
+		"expectedDelivery DATE," +
+		"created TIMESTAMPTZ NOT NULL DEFAULT now()," +
+		"version INT NOT NULL DEFAULT 1);")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS purchaseorderlines (" +
+		"ID SERIAL PRIMARY KEY," +
+		"purchaseOrderID INT references purchaseorders(id) ON DELETE CASCADE NOT NULL," +
+		"productID INT references products(id) NOT NULL," +
+		"quantity INT NOT NULL CHECK (quantity > 0)," +
+		"unitCost FLOAT NOT NULL CHECK (unitCost >= 0)," +
+		"received INT NOT NULL DEFAULT 0 CHECK (received BETWEEN 0 AND quantity));")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS purchaseorderlines_order ON purchaseorderlines (purchaseOrderID);")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec("ALTER TABLE inventorymovements ADD COLUMN IF NOT EXISTS purchaseOrderLineID INT references purchaseorderlines(id);")
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Methods for CRUD operations on the products table
+func CreateProduct(ctx context.Context, db DBTX, product *models.Product) (int, error) {
+	if err := validation.Validate(product); err != nil {
+		return -1, err
+	}
+	id, err := auditedCreate(ctx, db, "product", GetProduct, func(tx DBTX) (int, error) {
+		var id int
+		err := tx.QueryRowContext(ctx, "INSERT INTO products(name, price, size, color, sku) VALUES ($1, $2, $3, $4, NULLIF($5, '')) RETURNING id",
+			product.Name, product.Price, product.Size, product.Color, product.Sku).Scan(&id)
 		if err != nil {
-			return products, err
+			return id, err
 		}
-		products = append(products, product)
+		if err := setBarcodes(ctx, tx, id, product.Barcodes); err != nil {
+			return id, err
+		}
+		return id, recordPrice(ctx, tx, id)
+	})
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func GetProduct(ctx context.Context, db DBTX, id int) (models.Product, error) {
+	var product models.Product
+	row := db.QueryRowContext(ctx, "SELECT "+productColumns+" FROM products WHERE id = $1", id)
+	if row.Err() != nil {
+		return product, row.Err()
+	}
+	err := row.Scan(productDest(&product)...)
+	if err != nil {
+		return product, err
 	}
-	return products, nil
+	return product, nil
+}
+
+const productColumns = "id, name, price, COALESCE(size, ''), COALESCE(color, ''), COALESCE(sku, ''), " +
+	"ARRAY(SELECT code FROM barcodes WHERE barcodes.productid = products.id ORDER BY code), parentid, version"
+
+func productDest(product *models.Product) []any {
+	return []any{&product.Id, &product.Name, &product.Price, &product.Size, &product.Color, &product.Sku,
+		pq.Array(&product.Barcodes), &product.ParentId, &product.Version}
+}
+
+var productList = listSpec{
+	from: "products",
+	key:  "id",
+	filters: map[string]string{
+		"name":      "name ILIKE ?",
+		"size":      "size = ?",
+		"color":     "color = ?",
+		"parent":    "parentid = ?",
+		"sku":       "sku = ?",
+		"barcode":   "id IN (SELECT productid FROM barcodes WHERE gtin = lpad(?, 14, '0'))",
+		"category":  "COALESCE(parentid, id) IN (SELECT productid FROM productscategories WHERE categoryid IN (" + categorySubtrees + "))",
+		"min_price": "price >= ?",
+		"max_price": "price <= ?",
+	},
+	transform: map[string]func(string) string{"name": contains},
+	sorts: map[string]string{
+		"id":    "id",
+		"name":  "name",
+		"price": "price",
+		"size":  "COALESCE(size, '')",
+		"color": "COALESCE(color, '')",
+	},
+	defaultSort: "id",
+}
+
+// GetProducts returns a page of the products matching the filters of the query
+func GetProducts(ctx context.Context, db DBTX, q models.ListQuery) (models.Page[models.Product], error) {
+	return listPage(ctx, db, productList, productColumns, q, productDest)
 }
 
-func UpdateProduct(db *sql.DB, product models.Product) error {
-	err := db.QueryRow("UPDATE products "+
-		"SET name = $1, price = $2, size = $3, color = $4 "+
-		"WHERE id = $5", product.Name, product.Price, product.Size, product.Color, product.Id)
-	if err.Err() != nil {
-		return err.Err()
+// UpdateProduct updates the product if it is still at product.Version, and returns its new version.
+// The name and price are passed on to the variants of the product, except for overridden prices.
+// Variants themselves are changed with UpdateVariant. A new price is added to the price history.
+func UpdateProduct(ctx context.Context, db DBTX, product models.Product) (int, error) {
+	if err := validation.Validate(product); err != nil {
+		return -1, err
+	}
+	var version int
+	err := auditedUpdate(ctx, db, "product", product.Id, GetProduct, func(tx DBTX) error {
+		price, err := lockedPrice(ctx, tx, product.Id)
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRowContext(ctx, "UPDATE products "+
+			"SET name = $1, price = $2, size = $3, color = $4, sku = NULLIF($5, ''), version = version + 1 "+
+			"WHERE id = $6 AND version = $7 AND parentid IS NULL RETURNING version",
+			product.Name, product.Price, product.Size, product.Color, product.Sku, product.Id, product.Version).Scan(&version)
+		if errors.Is(err, sql.ErrNoRows) {
+			return productConflict(ctx, tx, product.Id)
+		}
+		if err != nil {
+			return err
+		}
+		if err := setBarcodes(ctx, tx, product.Id, product.Barcodes); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE products SET name = $1, price = COALESCE(priceoverride, $2), version = version + 1 "+
+			"WHERE parentid = $3 AND (name <> $1 OR price <> COALESCE(priceoverride, $2))", product.Name, product.Price, product.Id)
+		if err != nil || price == product.Price {
+			return err
+		}
+		return recordPrice(ctx, tx, product.Id)
+	})
+	if err != nil {
+		return -1, err
 	}
-	return nil
+	return version, nil
 }
 
-func DeleteProduct(db *sql.DB, id int) error {
-	_, err := db.Exec("DELETE FROM products WHERE id = $1", id)
+// productConflict finds out why a product update changed no row: the product does not
+// exist, it is a variant, or it has another version
+func productConflict(ctx context.Context, db DBTX, id int) error {
+	var parent sql.NullInt32
+	err := db.QueryRowContext(ctx, "SELECT parentid FROM products WHERE id = $1", id).Scan(&parent)
 	if err != nil {
 		return err
 	}
-	return nil
+	if parent.Valid {
+		return invalidf("product %d is a variant, change it at /products/%d/variants/%d", id, parent.Int32, id)
+	}
+	return ErrVersionConflict
+}
+
+// DeleteProduct deletes the product if it is still at the given version
+func DeleteProduct(ctx context.Context, db DBTX, id int, version int) error {
+	return auditedDelete(ctx, db, "product", id, GetProduct, func(tx DBTX) error {
+		return versionedExec(ctx, tx, "products", "id", id, "DELETE FROM products WHERE id = $1 AND version = $2", id, version)
+	})
 }
 
 // Methods for performing CRUD on customer table
-func CreateCustomer(db *sql.DB, customer models.Customer) (int, error) {
-	row := db.QueryRow("INSERT INTO customers(firstname, lastname, phonenumber, email, street, city, country) VALUES ("+
-		"$1, $2, $3, $4, $5, $6, $7) RETURNING id;", customer.FirstName, customer.LastName, customer.Phone, customer.Email, customer.Address.Street, customer.Address.City, customer.Address.Country)
-	if row.Err() != nil {
-		return -1, row.Err()
+func CreateCustomer(ctx context.Context, db DBTX, customer models.Customer) (int, error) {
+	if err := validation.Validate(customer); err != nil {
+		return -1, err
 	}
-	var id int
-	err := row.Scan(&id)
+	id, err := auditedCreate(ctx, db, "customer", GetCustomer, func(tx DBTX) (int, error) {
+		var id int
+		err := tx.QueryRowContext(ctx, "INSERT INTO customers(firstname, lastname, phonenumber, email, street, city, country) VALUES ("+
+			"$1, $2, $3, $4, $5, $6, $7) RETURNING id;", customer.FirstName, customer.LastName, customer.Phone, customer.Email, customer.Address.Street, customer.Address.City, customer.Address.Country).Scan(&id)
+		return id, err
+	})
 	if err != nil {
 		return -1, err
 	}
 	return id, nil
 }
 
-func GetCustomer(db *sql.DB, id int) (models.Customer, error) {
+func GetCustomer(ctx context.Context, db DBTX, id int) (models.Customer, error) {
 	var customer models.Customer
 	var addressStreet sql.NullString
 	var addressCity sql.NullString
 	var addressCountry sql.NullString
 
-	row := db.QueryRow("SELECT * FROM customers WHERE id = $1", id)
+	row := db.QueryRowContext(ctx, "SELECT id, firstname, lastname, phonenumber, email, street, city, country, version FROM customers WHERE id = $1", id)
 	if row.Err() != nil {
 		return customer, row.Err()
 	}
 
-	err := row.Scan(&customer.Id, &customer.FirstName, &customer.LastName, &customer.Phone, &customer.Email, &addressStreet, &addressCity, &addressCountry)
+	err := row.Scan(&customer.Id, &customer.FirstName, &customer.LastName, &customer.Phone, &customer.Email, &addressStreet, &addressCity, &addressCountry, &customer.Version)
 	if err != nil {
 		return customer, err
 	}
@@ -217,165 +605,202 @@ func GetCustomer(db *sql.DB, id int) (models.Customer, error) {
 	return customer, nil
 }
 
-func GetCustomers(db *sql.DB) ([]models.Customer, error) {
-	var customers []models.Customer
-	rows, err := db.Query("SELECT * FROM customers")
-	if err != nil {
-		return customers, err
-	}
-	defer func(rows *sql.Rows) {
-		err := rows.Close()
-		if err != nil {
-
-		}
-	}(rows)
-
-	for rows.Next() {
-		var customer models.Customer
-		var addressStreet sql.NullString
-		var addressCity sql.NullString
-		var addressCountry sql.NullString
-		err := rows.Scan(&customer.Id, &customer.FirstName, &customer.LastName, &customer.Phone, &customer.Email, &addressStreet, &addressCity, &addressCountry)
-		if err != nil {
-			return customers, err
-		}
-		customer.Address.Street = addressStreet.String
-		customer.Address.City = addressCity.String
-		customer.Address.Country = addressCountry.String
-		customers = append(customers, customer)
-	}
-	return customers, nil
+var customerList = listSpec{
+	from: "customers",
+	key:  "id",
+	filters: map[string]string{
+		"name":    "(firstname || ' ' || lastname) ILIKE ?",
+		"email":   "email ILIKE ?",
+		"phone":   "phonenumber = ?",
+		"city":    "city = ?",
+		"country": "country = ?",
+	},
+	transform: map[string]func(string) string{"name": contains, "email": contains},
+	sorts: map[string]string{
+		"id":        "id",
+		"firstName": "firstname",
+		"lastName":  "lastname",
+		"email":     "email",
+		"city":      "COALESCE(city, '')",
+	},
+	defaultSort: "id",
 }
 
-func UpdateCustomer(db *sql.DB, customer models.Customer) error {
-	err := db.QueryRow("UPDATE customers "+
-		"SET firstname = $1, lastname = $2, phonenumber = $3, email = $4, street = $5, city = $6, country = $7"+
-		"WHERE id = $8", customer.FirstName, customer.LastName, customer.Phone, customer.Email, customer.Address.Street, customer.Address.City, customer.Address.Country, customer.Id)
-	if err.Err() != nil {
-		return err.Err()
-	}
-	return nil
+// GetCustomers returns a page of the customers matching the filters of the query
+func GetCustomers(ctx context.Context, db DBTX, q models.ListQuery) (models.Page[models.Customer], error) {
+	return listPage(ctx, db, customerList, "id, firstname, lastname, phonenumber, email, COALESCE(street, ''), COALESCE(city, ''), COALESCE(country, ''), version", q, func(customer *models.Customer) []any {
+		return []any{&customer.Id, &customer.FirstName, &customer.LastName, &customer.Phone, &customer.Email, &customer.Address.Street, &customer.Address.City, &customer.Address.Country, &customer.Version}
+	})
 }
 
-func DeleteCustomer(db *sql.DB, id int) error {
-	_, err := db.Exec("DELETE FROM customers WHERE id = $1", id)
-	if err != nil {
+// UpdateCustomer updates the customer if it is still at customer.Version, and returns its new version
+func UpdateCustomer(ctx context.Context, db DBTX, customer models.Customer) (int, error) {
+	if err := validation.Validate(customer); err != nil {
+		return -1, err
+	}
+	var version int
+	err := auditedUpdate(ctx, db, "customer", customer.Id, GetCustomer, func(tx DBTX) error {
+		err := tx.QueryRowContext(ctx, "UPDATE customers "+
+			"SET firstname = $1, lastname = $2, phonenumber = $3, email = $4, street = $5, city = $6, country = $7, version = version + 1 "+
+			"WHERE id = $8 AND version = $9 RETURNING version", customer.FirstName, customer.LastName, customer.Phone, customer.Email, customer.Address.Street, customer.Address.City, customer.Address.Country, customer.Id, customer.Version).Scan(&version)
+		if errors.Is(err, sql.ErrNoRows) {
+			return versionConflict(ctx, tx, "customers", "id", customer.Id)
+		}
 		return err
+	})
+	if err != nil {
+		return -1, err
 	}
-	return nil
+	return version, nil
+}
+
+// DeleteCustomer deletes the customer if it is still at the given version
+func DeleteCustomer(ctx context.Context, db DBTX, id int, version int) error {
+	return auditedDelete(ctx, db, "customer", id, GetCustomer, func(tx DBTX) error {
+		return versionedExec(ctx, tx, "customers", "id", id, "DELETE FROM customers WHERE id = $1 AND version = $2", id, version)
+	})
 }
 
 // Methods for performing CRUD on customer table
-func CreateManufacturer(db *sql.DB, manufacturer models.Manufacturer) (int, error) {
-	row := db.QueryRow("INSERT INTO manufacturers (name, phone) VALUES ($1, $2) RETURNING id;", manufacturer.Name, manufacturer.Phone)
-	if row.Err() != nil {
-		return -1, row.Err()
+func CreateManufacturer(ctx context.Context, db DBTX, manufacturer models.Manufacturer) (int, error) {
+	if err := validation.Validate(manufacturer); err != nil {
+		return -1, err
 	}
-
-	var id int
-	err := row.Scan(&id)
+	id, err := auditedCreate(ctx, db, "manufacturer", GetManufacturer, func(tx DBTX) (int, error) {
+		var id int
+		err := tx.QueryRowContext(ctx, "INSERT INTO manufacturers (name, phone) VALUES ($1, $2) RETURNING id;", manufacturer.Name, manufacturer.Phone).Scan(&id)
+		return id, err
+	})
 	if err != nil {
 		return -1, err
 	}
 	return id, nil
 }
 
-func GetManufacturer(db *sql.DB, id int) (models.Manufacturer, error) {
+func GetManufacturer(ctx context.Context, db DBTX, id int) (models.Manufacturer, error) {
 	var manufacturer models.Manufacturer
-	row := db.QueryRow("SELECT * FROM manufacturers WHERE id = $1", id)
+	row := db.QueryRowContext(ctx, "SELECT id, name, phone, version FROM manufacturers WHERE id = $1", id)
 	if row.Err() != nil {
 		return manufacturer, row.Err()
 	}
-	err := row.Scan(&manufacturer.Id, &manufacturer.Name, &manufacturer.Phone)
+	err := row.Scan(&manufacturer.Id, &manufacturer.Name, &manufacturer.Phone, &manufacturer.Version)
 	if err != nil {
 		return manufacturer, err
 	}
 	return manufacturer, nil
 }
 
-func GetManufacturers(db *sql.DB) ([]models.Manufacturer, error) {
-	var manufacturers []models.Manufacturer
-	rows, err := db.Query("SELECT * FROM manufacturers")
-	if err != nil {
-		return manufacturers, err
-	}
-	for rows.Next() {
-		var manufacturer models.Manufacturer
-		err := rows.Scan(&manufacturer.Id, &manufacturer.Name, &manufacturer.Phone)
-		if err != nil {
-			return manufacturers, err
-		}
-		manufacturers = append(manufacturers, manufacturer)
-	}
-	return manufacturers, nil
+var manufacturerList = listSpec{
+	from: "manufacturers",
+	key:  "id",
+	filters: map[string]string{
+		"name":  "name ILIKE ?",
+		"phone": "phone = ?",
+	},
+	transform: map[string]func(string) string{"name": contains},
+	sorts: map[string]string{
+		"id":   "id",
+		"name": "name",
+	},
+	defaultSort: "id",
 }
 
-func UpdateManufacturer(db *sql.DB, manufacturer models.Manufacturer) error {
-	err := db.QueryRow("UPDATE manufacturers "+
-		"SET name = $1, phone = $2 WHERE id = $3;", manufacturer.Name, manufacturer.Phone, manufacturer.Id)
-	if err.Err() != nil {
-		return err.Err()
-	}
-	return nil
+// GetManufacturers returns a page of the manufacturers matching the filters of the query
+func GetManufacturers(ctx context.Context, db DBTX, q models.ListQuery) (models.Page[models.Manufacturer], error) {
+	return listPage(ctx, db, manufacturerList, "id, name, phone, version", q, func(manufacturer *models.Manufacturer) []any {
+		return []any{&manufacturer.Id, &manufacturer.Name, &manufacturer.Phone, &manufacturer.Version}
+	})
 }
 
-func DeleteManufacturer(db *sql.DB, id int) error {
-	_, err := db.Exec("DELETE FROM manufacturers WHERE id = $1", id)
-	if err != nil {
+// UpdateManufacturer updates the manufacturer if it is still at manufacturer.Version, and returns its new version
+func UpdateManufacturer(ctx context.Context, db DBTX, manufacturer models.Manufacturer) (int, error) {
+	if err := validation.Validate(manufacturer); err != nil {
+		return -1, err
+	}
+	var version int
+	err := auditedUpdate(ctx, db, "manufacturer", manufacturer.Id, GetManufacturer, func(tx DBTX) error {
+		err := tx.QueryRowContext(ctx, "UPDATE manufacturers "+
+			"SET name = $1, phone = $2, version = version + 1 WHERE id = $3 AND version = $4 RETURNING version;",
+			manufacturer.Name, manufacturer.Phone, manufacturer.Id, manufacturer.Version).Scan(&version)
+		if errors.Is(err, sql.ErrNoRows) {
+			return versionConflict(ctx, tx, "manufacturers", "id", manufacturer.Id)
+		}
 		return err
+	})
+	if err != nil {
+		return -1, err
 	}
-	return nil
+	return version, nil
 }
 
-func AssociateManufacturers(db *sql.DB, id int, manufacturers []int) error {
-	for _, manufacturer := range manufacturers {
-		_, err := db.Exec("INSERT INTO productsmanufacturers (productid, manufacturerid) VALUES ($1, $2)", id, manufacturer)
-		if err != nil {
-			return err
+// DeleteManufacturer deletes the manufacturer if it is still at the given version
+func DeleteManufacturer(ctx context.Context, db DBTX, id int, version int) error {
+	return auditedDelete(ctx, db, "manufacturer", id, GetManufacturer, func(tx DBTX) error {
+		return versionedExec(ctx, tx, "manufacturers", "id", id, "DELETE FROM manufacturers WHERE id = $1 AND version = $2", id, version)
+	})
+}
+
+// AssociateManufacturers links the product to the manufacturers. Either all of them are linked or none.
+func AssociateManufacturers(ctx context.Context, db DBTX, id int, manufacturers []int) error {
+	return InTx(ctx, db, func(tx DBTX) error {
+		for _, manufacturer := range manufacturers {
+			_, err := tx.ExecContext(ctx, "INSERT INTO productsmanufacturers (productid, manufacturerid) VALUES ($1, $2)", id, manufacturer)
+			if err != nil {
+				return err
+			}
 		}
-	}
-	return nil
+		return audit(ctx, tx, "productmanufacturers", id, nil, manufacturers)
+	})
 }
 
-func DeleteAssociationManufacturers(db *sql.DB, id int, manufacturers []int) error {
-	for _, manufacturer := range manufacturers {
-		_, err := db.Exec("DELETE FROM productsmanufacturers WHERE productid = $1 AND manufacturerid = $2", id, manufacturer)
-		if err != nil {
-			return err
+// DeleteAssociationManufacturers unlinks the product from the manufacturers. Either all of them are unlinked or none.
+func DeleteAssociationManufacturers(ctx context.Context, db DBTX, id int, manufacturers []int) error {
+	return InTx(ctx, db, func(tx DBTX) error {
+		for _, manufacturer := range manufacturers {
+			_, err := tx.ExecContext(ctx, "DELETE FROM productsmanufacturers WHERE productid = $1 AND manufacturerid = $2", id, manufacturer)
+			if err != nil {
+				return err
+			}
 		}
-	}
-	return nil
+		return audit(ctx, tx, "productmanufacturers", id, manufacturers, nil)
+	})
 }
 
-func CreateBike(db *sql.DB, bike models.Bike) (string, error) {
-	row := db.QueryRow("INSERT INTO bikes (productid, framenumber) VALUES ($1, $2) RETURNING framenumber;", bike.Id, bike.FrameNumber)
-	if row.Err() != nil {
-		return "", row.Err()
+func CreateBike(ctx context.Context, db DBTX, bike models.Bike) (string, error) {
+	if err := validation.Validate(bike); err != nil {
+		return "", err
 	}
-	var id string
-	err := row.Scan(&id)
+	id, err := auditedCreate(ctx, db, "bike", GetBike, func(tx DBTX) (string, error) {
+		var id string
+		err := tx.QueryRowContext(ctx, "INSERT INTO bikes (productid, framenumber) VALUES ($1, $2) RETURNING framenumber;", bike.Id, bike.FrameNumber).Scan(&id)
+		return id, err
+	})
 	if err != nil {
 		return "", err
 	}
 	return id, nil
 }
 
-func GetBike(db *sql.DB, framenumber string) (models.Bike, error) {
+func GetBike(ctx context.Context, db DBTX, framenumber string) (models.Bike, error) {
 	var owner sql.NullInt32
 	var bike models.Bike
 
-	row := db.QueryRow("SELECT * FROM bikes WHERE framenumber = $1", framenumber)
+	var soldAt sql.NullTime
+	row := db.QueryRowContext(ctx, "SELECT productid, framenumber, owner, soldat, version FROM bikes WHERE framenumber = $1", framenumber)
 	if row.Err() != nil {
 		return bike, row.Err()
 	}
 
-	err := row.Scan(&bike.Id, &bike.FrameNumber, &owner)
+	err := row.Scan(&bike.Id, &bike.FrameNumber, &owner, &soldAt, &bike.BikeVersion)
 	if err != nil {
 		return bike, err
 	}
+	if soldAt.Valid {
+		bike.SoldAt = &soldAt.Time
+	}
 
 	if owner.Valid {
-		owner, err := GetCustomer(db, int(owner.Int32))
+		owner, err := GetCustomer(ctx, db, int(owner.Int32))
 		if err != nil {
 			return bike, err
 		}
@@ -384,52 +809,100 @@ func GetBike(db *sql.DB, framenumber string) (models.Bike, error) {
 	return bike, nil
 }
 
-func GetBikes(db *sql.DB) ([]models.Bike, error) {
-	var bikes []models.Bike
-	rows, err := db.Query("SELECT * FROM bikes")
+var bikeList = listSpec{
+	from: "bikes",
+	key:  "framenumber",
+	filters: map[string]string{
+		"product":     "productid = ?",
+		"owner":       "owner = ?",
+		"frameNumber": "framenumber ILIKE ?",
+	},
+	transform: map[string]func(string) string{"frameNumber": contains},
+	sorts: map[string]string{
+		"frameNumber": "framenumber",
+		"product":     "productid",
+		"soldAt":      "COALESCE(soldat, '-infinity')",
+	},
+	defaultSort: "frameNumber",
+}
+
+// bikeRow holds the columns of a bike row before the owner is looked up
+type bikeRow struct {
+	bike   models.Bike
+	owner  sql.NullInt32
+	soldAt sql.NullTime
+}
+
+// GetBikes returns a page of the bikes matching the filters of the query, with their owners
+func GetBikes(ctx context.Context, db DBTX, q models.ListQuery) (models.Page[models.Bike], error) {
+	rows, err := listPage(ctx, db, bikeList, "productid, framenumber, owner, soldat, version", q, func(row *bikeRow) []any {
+		return []any{&row.bike.Id, &row.bike.FrameNumber, &row.owner, &row.soldAt, &row.bike.BikeVersion}
+	})
+	page := models.Page[models.Bike]{Items: []models.Bike{}, Total: rows.Total, NextCursor: rows.NextCursor}
 	if err != nil {
-		return bikes, err
+		return page, err
 	}
 
-	for rows.Next() {
-		var owner sql.NullInt32
-		var bike models.Bike
-		err := rows.Scan(&bike.Id, &bike.FrameNumber, &owner)
-		if err != nil {
-			return bikes, err
+	for _, row := range rows.Items {
+		bike := row.bike
+		if row.soldAt.Valid {
+			bike.SoldAt = &row.soldAt.Time
 		}
-		if owner.Valid {
-			o, err := GetCustomer(db, int(owner.Int32))
+		if row.owner.Valid {
+			o, err := GetCustomer(ctx, db, int(row.owner.Int32))
 			if err != nil {
-				return bikes, err
+				return page, err
 			}
 			bike.Owner = o
 		}
-		bikes = append(bikes, bike)
+		page.Items = append(page.Items, bike)
 	}
-	return bikes, nil
+	return page, nil
 }
 
-func DeleteBike(db *sql.DB, frameNumber string) error {
-	_, err := db.Exec("DELETE FROM bikes WHERE framenumber = $1", frameNumber)
-	if err != nil {
+// UpdateBike changes the product and owner of the bike if it is still at bike.BikeVersion, and
+// returns its new version. The bike is sold when it gets a new owner and unsold without one.
+func UpdateBike(ctx context.Context, db DBTX, bike models.Bike) (int, error) {
+	if err := validation.Validate(bike); err != nil {
+		return -1, err
+	}
+	owner := sql.NullInt32{Int32: int32(bike.Owner.Id), Valid: bike.Owner.Id != 0}
+	var version int
+	err := auditedUpdate(ctx, db, "bike", bike.FrameNumber, GetBike, func(tx DBTX) error {
+		err := tx.QueryRowContext(ctx, "UPDATE bikes SET productid = $1, owner = $2, "+
+			"soldat = CASE WHEN $2::INT IS NULL THEN NULL WHEN owner IS DISTINCT FROM $2::INT THEN now() ELSE soldat END, "+
+			"version = version + 1 WHERE framenumber = $3 AND version = $4 RETURNING version;",
+			bike.Id, owner, bike.FrameNumber, bike.BikeVersion).Scan(&version)
+		if errors.Is(err, sql.ErrNoRows) {
+			return versionConflict(ctx, tx, "bikes", "framenumber", bike.FrameNumber)
+		}
 		return err
+	})
+	if err != nil {
+		return -1, err
 	}
-	return nil
+	return version, nil
 }
 
-func AddOwner(db *sql.DB, frameNumber string, owner int) error {
-	_, err := db.Query("UPDATE bikes SET owner = $1 WHERE framenumber = $2;", owner, frameNumber)
-	if err != nil {
-		return err
-	}
-	return nil
+// DeleteBike deletes the bike if it is still at the given version
+func DeleteBike(ctx context.Context, db DBTX, frameNumber string, version int) error {
+	return auditedDelete(ctx, db, "bike", frameNumber, GetBike, func(tx DBTX) error {
+		return versionedExec(ctx, tx, "bikes", "framenumber", frameNumber, "DELETE FROM bikes WHERE framenumber = $1 AND version = $2", frameNumber, version)
+	})
 }
 
-func RemoveOwner(db *sql.DB, frameNumber string) error {
-	_, err := db.Query("UPDATE bikes SET owner = NULL WHERE framenumber = $1;", frameNumber)
-	if err != nil {
-		return err
-	}
-	return nil
+// AddOwner sells the bike to the owner if it is still at the given version
+func AddOwner(ctx context.Context, db DBTX, frameNumber string, owner int, version int) error {
+	return auditedUpdate(ctx, db, "bike", frameNumber, GetBike, func(tx DBTX) error {
+		return versionedExec(ctx, tx, "bikes", "framenumber", frameNumber,
+			"UPDATE bikes SET owner = $1, soldat = now(), version = version + 1 WHERE framenumber = $2 AND version = $3;", owner, frameNumber, version)
+	})
+}
+
+// RemoveOwner removes the owner of the bike if it is still at the given version
+func RemoveOwner(ctx context.Context, db DBTX, frameNumber string, version int) error {
+	return auditedUpdate(ctx, db, "bike", frameNumber, GetBike, func(tx DBTX) error {
+		return versionedExec(ctx, tx, "bikes", "framenumber", frameNumber,
+			"UPDATE bikes SET owner = NULL, soldat = NULL, version = version + 1 WHERE framenumber = $1 AND version = $2;", frameNumber, version)
+	})
 }
@@ -0,0 +1,17 @@
+package data
+
+import "fmt"
+
+// InvalidError is returned when the input to a data function is invalid,
+// as opposed to errors from the database itself.
+type InvalidError struct {
+	Message string
+}
+
+func (e *InvalidError) Error() string {
+	return e.Message
+}
+
+func invalidf(format string, args ...any) error {
+	return &InvalidError{Message: fmt.Sprintf(format, args...)}
+}
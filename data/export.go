@@ -0,0 +1,70 @@
+package data
+
+import (
+	"api/data/models"
+	"context"
+)
+
+// Functions for exporting whole tables. They call fn for each row as it is read, instead of
+// building a slice, so that large tables can be streamed.
+
+// ExportProducts exports the products and their variants, which have a parentId
+func ExportProducts(ctx context.Context, db DBTX, fn func(models.ExportedProduct) error) error {
+	return eachRow(ctx, db, "SELECT "+productColumns+", priceoverride, stock FROM products ORDER BY id",
+		func(p *models.ExportedProduct) []any {
+			return append(productDest(&p.Product), &p.PriceOverride, &p.Stock)
+		}, fn)
+}
+
+func ExportCustomers(ctx context.Context, db DBTX, fn func(models.Customer) error) error {
+	return eachRow(ctx, db, "SELECT id, firstname, lastname, phonenumber, email, COALESCE(street, ''), COALESCE(city, ''), COALESCE(country, ''), version "+
+		"FROM customers ORDER BY id",
+		func(c *models.Customer) []any {
+			return []any{&c.Id, &c.FirstName, &c.LastName, &c.Phone, &c.Email, &c.Address.Street, &c.Address.City, &c.Address.Country, &c.Version}
+		}, fn)
+}
+
+func ExportManufacturers(ctx context.Context, db DBTX, fn func(models.Manufacturer) error) error {
+	return eachRow(ctx, db, "SELECT id, name, phone, version FROM manufacturers ORDER BY id",
+		func(m *models.Manufacturer) []any {
+			return []any{&m.Id, &m.Name, &m.Phone, &m.Version}
+		}, fn)
+}
+
+// ExportBikes exports the bikes with their product and owner, which is empty for unsold bikes
+func ExportBikes(ctx context.Context, db DBTX, fn func(models.Bike) error) error {
+	return eachRow(ctx, db, "SELECT b.framenumber, p.id, p.name, p.price, COALESCE(p.size, ''), COALESCE(p.color, ''), b.soldat, b.version, "+
+		"COALESCE(c.id, 0), COALESCE(c.firstname, ''), COALESCE(c.lastname, ''), COALESCE(c.phonenumber, ''), COALESCE(c.email, ''), "+
+		"COALESCE(c.street, ''), COALESCE(c.city, ''), COALESCE(c.country, ''), COALESCE(c.version, 0) "+
+		"FROM bikes b JOIN products p ON p.id = b.productid LEFT JOIN customers c ON c.id = b.owner ORDER BY b.framenumber",
+		func(row *bikeRow) []any {
+			b, o := &row.bike, &row.bike.Owner
+			return []any{&b.FrameNumber, &b.Id, &b.Name, &b.Price, &b.Size, &b.Color, &row.soldAt, &b.BikeVersion,
+				&o.Id, &o.FirstName, &o.LastName, &o.Phone, &o.Email, &o.Address.Street, &o.Address.City, &o.Address.Country, &o.Version}
+		}, func(row bikeRow) error {
+			if row.soldAt.Valid {
+				row.bike.SoldAt = &row.soldAt.Time
+			}
+			return fn(row.bike)
+		})
+}
+
+// eachRow scans every row of the query into a T with the destinations returned by dest and passes it to fn
+func eachRow[T any](ctx context.Context, db DBTX, query string, dest func(*T) []any, fn func(T) error) error {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item T
+		if err := rows.Scan(dest(&item)...); err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
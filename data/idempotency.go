@@ -0,0 +1,82 @@
+package data
+
+import (
+	"api/data/models"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("the idempotency key has already been used for a different request")
+	ErrIdempotencyKeyInProgress = errors.New("a request with the idempotency key is still being processed")
+)
+
+// idempotencyLockTimeout is how long a key stays claimed by a request that has not finished.
+// A retry after that takes the key over, since the process handling the request has died.
+const idempotencyLockTimeout = time.Minute
+
+// StartIdempotentRequest claims the key of the owner for a request. It returns nil when the
+// request should be processed, or the stored response when the same request has been processed
+// before. A key whose request has not finished within idempotencyLockTimeout is claimed anew.
+func StartIdempotentRequest(ctx context.Context, db DBTX, owner string, key string, requestHash string) (*models.IdempotentResponse, error) {
+	result, err := db.ExecContext(ctx, "INSERT INTO idempotencykeys (owner, key, requesthash) VALUES ($1, $2, $3) "+
+		"ON CONFLICT (owner, key) DO UPDATE SET locked = now() WHERE idempotencykeys.status IS NULL "+
+		"AND idempotencykeys.requesthash = EXCLUDED.requesthash AND idempotencykeys.locked < $4",
+		owner, key, requestHash, time.Now().Add(-idempotencyLockTimeout))
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 1 {
+		return nil, err
+	}
+
+	var storedHash string
+	var status sql.NullInt32
+	var header []byte
+	var response models.IdempotentResponse
+	err = db.QueryRowContext(ctx, "SELECT requesthash, status, headers, body FROM idempotencykeys WHERE owner = $1 AND key = $2", owner, key).
+		Scan(&storedHash, &status, &header, &response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if storedHash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if !status.Valid {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	response.Status = int(status.Int32)
+	if err := json.Unmarshal(header, &response.Header); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// FinishIdempotentRequest stores the response to the request that claimed the key
+func FinishIdempotentRequest(ctx context.Context, db DBTX, owner string, key string, response models.IdempotentResponse) error {
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "UPDATE idempotencykeys SET status = $1, headers = $2, body = $3 WHERE owner = $4 AND key = $5",
+		response.Status, header, response.Body, owner, key)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys forgets the keys that are older than retention, and returns how many
+func DeleteExpiredIdempotencyKeys(ctx context.Context, db DBTX, retention time.Duration) (int64, error) {
+	result, err := db.ExecContext(ctx, "DELETE FROM idempotencykeys WHERE created < $1", time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// AbortIdempotentRequest releases the key, so that the request can be retried
+func AbortIdempotentRequest(ctx context.Context, db DBTX, owner string, key string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM idempotencykeys WHERE owner = $1 AND key = $2 AND status IS NULL", owner, key)
+	return err
+}
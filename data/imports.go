@@ -0,0 +1,282 @@
+package data
+
+import (
+	"api/data/models"
+	"api/validation"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Actions taken for the rows of a product import
+const (
+	ImportCreate    = "create"
+	ImportUpdate    = "update"
+	ImportUnchanged = "unchanged"
+	ImportError     = "error"
+)
+
+// errRollback makes InTx roll back an import that is a dry run or has failed rows
+var errRollback = errors.New("rollback")
+
+// ImportProducts creates or updates a product for every row, matched by name or sku as opts.Match says,
+// and links it to the manufacturers named in the row. The import runs in one transaction and is only
+// committed when every row succeeded and it is not a dry run, so a price list is imported whole or not at all.
+func ImportProducts(ctx context.Context, db DBTX, rows []models.ProductImportRow, opts models.ImportOptions) (models.ImportResult, error) {
+	result := models.ImportResult{DryRun: opts.DryRun, Rows: []models.ImportRowResult{}}
+	if opts.Match != "name" && opts.Match != "sku" {
+		return result, invalidf("products can only be matched on name or sku, not %q", opts.Match)
+	}
+
+	err := InTx(ctx, db, func(tx DBTX) error {
+		manufacturers, err := manufacturerIdsByName(ctx, tx)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			res, err := importProductRow(ctx, tx, row, manufacturers, opts)
+			if err != nil {
+				return err
+			}
+			switch res.Action {
+			case ImportCreate:
+				result.Created++
+			case ImportUpdate:
+				result.Updated++
+			case ImportUnchanged:
+				result.Unchanged++
+			default:
+				result.Failed++
+			}
+			result.Rows = append(result.Rows, res)
+		}
+		if opts.DryRun || result.Failed > 0 {
+			return errRollback
+		}
+		return nil
+	})
+	if errors.Is(err, errRollback) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	result.Committed = true
+	return result, nil
+}
+
+// importProductRow imports a single row inside a savepoint, so that a row failing in the
+// database is reported like any other row error instead of aborting the transaction
+func importProductRow(ctx context.Context, tx DBTX, row models.ProductImportRow, manufacturers map[string][]int, opts models.ImportOptions) (models.ImportRowResult, error) {
+	res := models.ImportRowResult{Line: row.Line, Action: ImportError, Errors: row.Errors}
+	fail := func(format string, args ...any) (models.ImportRowResult, error) {
+		res.Action = ImportError
+		res.Errors = append(res.Errors, fmt.Sprintf(format, args...))
+		return res, nil
+	}
+	if len(res.Errors) > 0 {
+		return res, nil
+	}
+	if opts.Match == "sku" && (row.Sku == nil || *row.Sku == "") {
+		return fail("sku is required to match on")
+	}
+	if opts.Match == "name" && strings.TrimSpace(row.Name) == "" {
+		return fail("name is required")
+	}
+
+	var ids []int
+	for _, name := range row.Manufacturers {
+		found := manufacturers[strings.ToLower(name)]
+		switch len(found) {
+		case 0:
+			return fail("unknown manufacturer: %s", name)
+		case 1:
+			ids = append(ids, found[0])
+		default:
+			return fail("%d manufacturers are named %s", len(found), name)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT import_row"); err != nil {
+		return res, err
+	}
+	res, err := importProduct(ctx, tx, res, row, ids, opts)
+	if err != nil {
+		if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_row"); rollbackErr != nil {
+			return res, rollbackErr
+		}
+		res.ProductId, res.Product, res.Before, res.Linked = 0, nil, nil, nil
+		return fail("%v", err)
+	}
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT import_row")
+	return res, err
+}
+
+// importProduct matches the row to an existing product and saves it. Problems with the row are
+// reported in res; an error means the database failed and the savepoint is rolled back.
+func importProduct(ctx context.Context, tx DBTX, res models.ImportRowResult, row models.ProductImportRow, manufacturers []int, opts models.ImportOptions) (models.ImportRowResult, error) {
+	fail := func(format string, args ...any) (models.ImportRowResult, error) {
+		res.Action = ImportError
+		res.Errors = append(res.Errors, fmt.Sprintf(format, args...))
+		return res, nil
+	}
+
+	var existing []models.Product
+	var err error
+	if opts.Match == "sku" {
+		existing, err = productsWithSku(ctx, tx, *row.Sku)
+	} else {
+		existing, err = productsNamed(ctx, tx, row.Name)
+	}
+	if err != nil {
+		return res, err
+	}
+	if len(existing) > 1 {
+		return fail("%d products are named %s", len(existing), row.Name)
+	}
+	if len(existing) == 1 && existing[0].ParentId != nil {
+		return fail("sku %s belongs to a variant of product %d", existing[0].Sku, *existing[0].ParentId)
+	}
+
+	var product models.Product
+	if len(existing) == 1 {
+		product = existing[0]
+		res.Before = &existing[0]
+	} else if row.Price == nil {
+		return fail("price is required for a new product")
+	} else if strings.TrimSpace(row.Name) == "" {
+		return fail("name is required for a new product")
+	}
+	if strings.TrimSpace(row.Name) != "" {
+		product.Name = row.Name
+	}
+	if row.Price != nil {
+		product.Price = *row.Price
+	}
+	if row.Size != nil {
+		product.Size = *row.Size
+	}
+	if row.Color != nil {
+		product.Color = *row.Color
+	}
+	if row.Sku != nil {
+		product.Sku = *row.Sku
+	}
+	if res.Before != nil && res.Before.Price != product.Price && !opts.AllowPriceChanges {
+		return fail("changing the price needs the %s permission", PermProductsPrice)
+	}
+	if err := validation.Validate(product); err != nil {
+		var fields validation.Errors
+		if errors.As(err, &fields) {
+			res.Action = ImportError
+			for _, f := range fields {
+				res.Errors = append(res.Errors, f.Field+" "+f.Message)
+			}
+			return res, nil
+		}
+		return res, err
+	}
+	return saveImportedProduct(ctx, tx, res, product, manufacturers)
+}
+
+func saveImportedProduct(ctx context.Context, tx DBTX, res models.ImportRowResult, product models.Product, manufacturers []int) (models.ImportRowResult, error) {
+	switch {
+	case res.Before == nil:
+		id, err := CreateProduct(ctx, tx, &product)
+		if err != nil {
+			return res, err
+		}
+		product.Id, product.Version = id, FirstVersion
+		res.Action = ImportCreate
+	case productChanged(*res.Before, product):
+		version, err := UpdateProduct(ctx, tx, product)
+		if err != nil {
+			return res, err
+		}
+		product.Version = version
+		res.Action = ImportUpdate
+	default:
+		res.Action = ImportUnchanged
+	}
+	res.ProductId, res.Product = product.Id, &product
+
+	for _, manufacturer := range manufacturers {
+		linked, err := tx.ExecContext(ctx, "INSERT INTO productsmanufacturers (productid, manufacturerid) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			product.Id, manufacturer)
+		if err != nil {
+			return res, err
+		}
+		if n, _ := linked.RowsAffected(); n > 0 {
+			res.Linked = append(res.Linked, manufacturer)
+		}
+	}
+	if len(res.Linked) == 0 {
+		return res, nil
+	}
+	if res.Action == ImportUnchanged {
+		res.Action = ImportUpdate
+	}
+	return res, audit(ctx, tx, "productmanufacturers", product.Id, nil, res.Linked)
+}
+
+// productChanged reports whether an imported row changes the product. Barcodes are
+// not imported, so they are the same on both.
+func productChanged(before models.Product, after models.Product) bool {
+	return before.Name != after.Name || before.Price != after.Price || before.Size != after.Size ||
+		before.Color != after.Color || before.Sku != after.Sku
+}
+
+func productsNamed(ctx context.Context, db DBTX, name string) ([]models.Product, error) {
+	var products []models.Product
+	rows, err := db.QueryContext(ctx, "SELECT "+productColumns+" FROM products WHERE lower(name) = lower($1) AND parentid IS NULL", name)
+	if err != nil {
+		return products, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var product models.Product
+		err := rows.Scan(productDest(&product)...)
+		if err != nil {
+			return products, err
+		}
+		products = append(products, product)
+	}
+	return products, rows.Err()
+}
+
+// productsWithSku returns the product or variant with the sku, which is unique
+func productsWithSku(ctx context.Context, db DBTX, sku string) ([]models.Product, error) {
+	var products []models.Product
+	var product models.Product
+	err := db.QueryRowContext(ctx, "SELECT "+productColumns+" FROM products WHERE sku = $1", sku).Scan(productDest(&product)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return products, nil
+	}
+	if err != nil {
+		return products, err
+	}
+	return append(products, product), nil
+}
+
+// manufacturerIdsByName maps the lower case names of the manufacturers to their ids
+func manufacturerIdsByName(ctx context.Context, db DBTX) (map[string][]int, error) {
+	ids := map[string][]int{}
+	rows, err := db.QueryContext(ctx, "SELECT id, lower(name) FROM manufacturers ORDER BY id")
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return ids, err
+		}
+		ids[name] = append(ids[name], id)
+	}
+	return ids, rows.Err()
+}
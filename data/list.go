@@ -0,0 +1,164 @@
+package data
+
+import (
+	"api/data/models"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// listSpec describes how a collection can be filtered and sorted
+type listSpec struct {
+	// from is the FROM clause of the queries
+	from string
+	// key is a unique column used to break ties when sorting, so the cursor is stable
+	key string
+	// filters maps a query parameter to a condition taking the value as its single argument
+	filters map[string]string
+	// sorts maps a sortable field to the expression it sorts on
+	sorts       map[string]string
+	defaultSort string
+	// transform adjusts the filter value before it is used, e.g. adding wildcards
+	transform map[string]func(string) string
+}
+
+// cursor points at the last row of a page by its sort value and key
+type cursor struct {
+	Value string `json:"v"`
+	Key   string `json:"k"`
+}
+
+// listQueries holds the built SQL for a page and for counting the matching rows
+type listQueries struct {
+	items     string
+	itemsArgs []any
+	count     string
+	countArgs []any
+	limit     int
+}
+
+func contains(value string) string {
+	return "%" + value + "%"
+}
+
+// build creates the queries selecting columns for the page described by q. The items
+// query selects two extra columns last: the sort value and the key as text, used for the cursor.
+func (s listSpec) build(columns string, q models.ListQuery) (listQueries, error) {
+	var built listQueries
+	var conditions []string
+	var args []any
+	for name, value := range q.Filters {
+		condition, ok := s.filters[name]
+		if !ok {
+			return built, invalidf("unknown filter: %s", name)
+		}
+		if t, ok := s.transform[name]; ok {
+			value = t(value)
+		}
+		args = append(args, value)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", "$"+strconv.Itoa(len(args))))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	built.count = "SELECT COUNT(*) FROM " + s.from + where
+	built.countArgs = append([]any{}, args...)
+
+	sort := q.Sort
+	if sort == "" {
+		sort = s.defaultSort
+	}
+	direction, comparison := "ASC", ">"
+	if strings.HasPrefix(sort, "-") {
+		sort = sort[1:]
+		direction, comparison = "DESC", "<"
+	}
+	expr, ok := s.sorts[sort]
+	if !ok {
+		return built, invalidf("unknown sort field: %s", sort)
+	}
+
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return built, err
+		}
+		args = append(args, c.Value, c.Key)
+		v, k := "$"+strconv.Itoa(len(args)-1), "$"+strconv.Itoa(len(args))
+		conditions = append(conditions, "("+expr+" "+comparison+" "+v+" OR ("+expr+" = "+v+" AND "+s.key+" "+comparison+" "+k+"))")
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	built.limit = q.Limit
+	if built.limit <= 0 {
+		built.limit = DefaultLimit
+	}
+	if built.limit > MaxLimit {
+		built.limit = MaxLimit
+	}
+	args = append(args, built.limit+1)
+	built.items = "SELECT " + columns + ", (" + expr + ")::text, (" + s.key + ")::text FROM " + s.from + where +
+		" ORDER BY " + expr + " " + direction + ", " + s.key + " " + direction +
+		" LIMIT $" + strconv.Itoa(len(args))
+	built.itemsArgs = args
+	return built, nil
+}
+
+func encodeCursor(value string, key string) string {
+	j, _ := json.Marshal(cursor{Value: value, Key: key})
+	return base64.RawURLEncoding.EncodeToString(j)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	j, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, invalidf("invalid cursor")
+	}
+	if err := json.Unmarshal(j, &c); err != nil {
+		return c, invalidf("invalid cursor")
+	}
+	return c, nil
+}
+
+// listPage runs the queries built from spec and q. dest returns the scan destinations
+// for the columns of a row.
+func listPage[T any](ctx context.Context, db DBTX, spec listSpec, columns string, q models.ListQuery, dest func(*T) []any) (models.Page[T], error) {
+	page := models.Page[T]{Items: []T{}}
+	built, err := spec.build(columns, q)
+	if err != nil {
+		return page, err
+	}
+	err = db.QueryRowContext(ctx, built.count, built.countArgs...).Scan(&page.Total)
+	if err != nil {
+		return page, err
+	}
+
+	rows, err := db.QueryContext(ctx, built.items, built.itemsArgs...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	var value, key string
+	for rows.Next() {
+		if len(page.Items) == built.limit {
+			page.NextCursor = encodeCursor(value, key)
+			break
+		}
+		var item T
+		if err := rows.Scan(append(dest(&item), &value, &key)...); err != nil {
+			return page, err
+		}
+		page.Items = append(page.Items, item)
+	}
+	return page, rows.Err()
+}
@@ -0,0 +1,105 @@
+package data
+
+import (
+	"api/data/models"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		value, key string
+	}{
+		{"", ""},
+		{"Helmet", "42"},
+		{"1299.50", "7"},
+		{"Ærlig & \"quoted\" / ?=+", "3"},
+		{strings.Repeat("x", 300), "123456"},
+	}
+	for _, tt := range tests {
+		encoded := encodeCursor(tt.value, tt.key)
+		if strings.ContainsAny(encoded, "+/=") {
+			t.Errorf("encodeCursor(%q, %q) = %q, which is not safe in a query string", tt.value, tt.key, encoded)
+		}
+		c, err := decodeCursor(encoded)
+		if err != nil {
+			t.Errorf("decodeCursor(%q) = %v", encoded, err)
+			continue
+		}
+		if c.Value != tt.value || c.Key != tt.key {
+			t.Errorf("decodeCursor(encodeCursor(%q, %q)) = %+v", tt.value, tt.key, c)
+		}
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	for _, s := range []string{"not base64!", "bm90IGpzb24", "W10"} {
+		_, err := decodeCursor(s)
+		var invalid *InvalidError
+		if !errors.As(err, &invalid) {
+			t.Errorf("decodeCursor(%q) = %v, want an InvalidError", s, err)
+		}
+	}
+}
+
+func TestListSpecBuild(t *testing.T) {
+	spec := listSpec{
+		from:        "things",
+		key:         "id",
+		filters:     map[string]string{"name": "name ILIKE ?"},
+		sorts:       map[string]string{"name": "name", "id": "id"},
+		defaultSort: "id",
+		transform:   map[string]func(string) string{"name": contains},
+	}
+	tests := []struct {
+		name      string
+		q         models.ListQuery
+		wantItems string
+		wantArgs  []any
+		wantErr   bool
+	}{
+		{
+			name:      "default sort and limit",
+			q:         models.ListQuery{},
+			wantItems: "SELECT id, name, (id)::text, (id)::text FROM things ORDER BY id ASC, id ASC LIMIT $1",
+			wantArgs:  []any{DefaultLimit + 1},
+		},
+		{
+			name:      "filter, descending sort and cursor",
+			q:         models.ListQuery{Filters: map[string]string{"name": "bell"}, Sort: "-name", Cursor: encodeCursor("Bell", "9"), Limit: 10},
+			wantItems: "SELECT id, name, (name)::text, (id)::text FROM things WHERE name ILIKE $1 AND (name < $2 OR (name = $2 AND id < $3)) ORDER BY name DESC, id DESC LIMIT $4",
+			wantArgs:  []any{"%bell%", "Bell", "9", 11},
+		},
+		{
+			name:      "limit is capped",
+			q:         models.ListQuery{Limit: MaxLimit * 2},
+			wantItems: "SELECT id, name, (id)::text, (id)::text FROM things ORDER BY id ASC, id ASC LIMIT $1",
+			wantArgs:  []any{MaxLimit + 1},
+		},
+		{name: "unknown filter", q: models.ListQuery{Filters: map[string]string{"color": "red"}}, wantErr: true},
+		{name: "unknown sort", q: models.ListQuery{Sort: "price"}, wantErr: true},
+		{name: "bad cursor", q: models.ListQuery{Cursor: "?"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			built, err := spec.build("id, name", tt.q)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("build() = %q, want an error", built.items)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if built.items != tt.wantItems {
+				t.Errorf("items query\n got %s\nwant %s", built.items, tt.wantItems)
+			}
+			if !reflect.DeepEqual(built.itemsArgs, tt.wantArgs) {
+				t.Errorf("items args = %v, want %v", built.itemsArgs, tt.wantArgs)
+			}
+		})
+	}
+}
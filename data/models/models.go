@@ -1,36 +1,484 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Product struct {
 	Id    int     `json:"id"`
-	Name  string  `json:"name"`
-	Price float32 `json:"price"`
-	Size  string  `json:"size"`
-	Color string  `json:"color"`
+	Name  string  `json:"name" validate:"required,max=255"`
+	Price float32 `json:"price" validate:"min=0,max=1000000"`
+	Size  string  `json:"size" validate:"max=255"`
+	Color string  `json:"color" validate:"max=255"`
+
+	// Sku is the shop's own stock keeping unit, and Barcodes are the GTINs printed on the product
+	Sku      string   `json:"sku" validate:"max=64"`
+	Barcodes []string `json:"barcodes" validate:"gtin"`
+
+	// ParentId is set when the product is a variant of another product
+	ParentId *int `json:"parentId,omitempty"`
+
+	// Version is incremented on every update and is sent as the ETag
+	Version int `json:"version"`
+}
+
+// ExportedProduct is a product as it is exported, with the price override of a variant
+// and the stock, which are not part of the product resource
+type ExportedProduct struct {
+	Product
+	PriceOverride *float32 `json:"priceOverride"`
+	Stock         int      `json:"stock"`
+}
+
+// Variant is a variant of a parent product, such as one size and color of a helmet. Variants are
+// stored as products sharing the name of their parent, so GET /products/{id} also works for them.
+// Price overrides the price of the parent when it is set, and EffectivePrice is the price charged.
+type Variant struct {
+	Id             int      `json:"id"`
+	ParentId       int      `json:"parentId"`
+	Sku            string   `json:"sku" validate:"max=64"`
+	Barcodes       []string `json:"barcodes" validate:"gtin"`
+	Size           string   `json:"size" validate:"max=255"`
+	Color          string   `json:"color" validate:"max=255"`
+	FrameSize      string   `json:"frameSize" validate:"max=255"`
+	WheelSize      string   `json:"wheelSize" validate:"max=255"`
+	Price          *float32 `json:"price" validate:"min=0,max=1000000"`
+	EffectivePrice float32  `json:"effectivePrice"`
+	Stock          int      `json:"stock" validate:"min=0"`
+	Version        int      `json:"version"`
+}
+
+// ScannedProduct is the product found by a scanned barcode or sku. Variant is set when
+// the code belongs to a variant, and Product is then the product it is a variant of.
+type ScannedProduct struct {
+	MatchedBy string   `json:"matchedBy"`
+	Product   Product  `json:"product"`
+	Variant   *Variant `json:"variant,omitempty"`
+}
+
+// Category is a node of the catalogue tree, e.g. bikes > e-bikes > cargo.
+// A category without a parent is at the top of the tree.
+type Category struct {
+	Id       int    `json:"id"`
+	Name     string `json:"name" validate:"required,max=255"`
+	ParentId *int   `json:"parentId"`
+	Version  int    `json:"version"`
+}
+
+// CategoryNode is a category with its subcategories
+type CategoryNode struct {
+	Category
+	Children []CategoryNode `json:"children"`
 }
 
 type Manufacturer struct {
-	Id    int    `json:"id"`
-	Name  string `json:"name"`
-	Phone string `json:"phone"`
+	Id      int    `json:"id"`
+	Name    string `json:"name" validate:"required,max=255"`
+	Phone   string `json:"phone" validate:"required,phone"`
+	Version int    `json:"version"`
 }
 
+// Bike only validates its own fields, since the product and owner are referred to by id
 type Bike struct {
-	Product
-	FrameNumber string   `json:"frameNumber"`
-	Owner       Customer `json:"owner"`
+	Product     `validate:"-"`
+	FrameNumber string     `json:"frameNumber" validate:"required,framenumber"`
+	Owner       Customer   `json:"owner" validate:"-"`
+	SoldAt      *time.Time `json:"soldAt,omitempty"`
+
+	// BikeVersion is the version of the bike itself, which its ETag is made of. Its json name
+	// takes precedence over the version of the embedded product.
+	BikeVersion int `json:"version"`
 }
 
 type Customer struct {
 	Id        int     `json:"id"`
-	FirstName string  `json:"firstName"`
-	LastName  string  `json:"lastName"`
+	FirstName string  `json:"firstName" validate:"required,max=255"`
+	LastName  string  `json:"lastName" validate:"required,max=255"`
 	Address   Address `json:"address"`
-	Phone     string  `json:"phone"`
-	Email     string  `json:"email"`
+	Phone     string  `json:"phone" validate:"required,phone"`
+	Email     string  `json:"email" validate:"required,email,max=255"`
+	Version   int     `json:"version"`
 }
 
 type Address struct {
-	Street  string `json:"street"`
-	City    string `json:"city"`
-	Country string `json:"country"`
+	Street  string `json:"street" validate:"max=255"`
+	City    string `json:"city" validate:"max=255"`
+	Country string `json:"country" validate:"max=255"`
+}
+
+type Workcard struct {
+	Id          int       `json:"id"`
+	Status      string    `json:"status"`
+	FrameNumber string    `json:"frameNumber"`
+	Created     time.Time `json:"created"`
+	// EstimatedMinutes is nil when a request leaves it out, which keeps the current estimate on update
+	EstimatedMinutes *int `json:"estimatedMinutes"`
+}
+
+// ReminderRule describes when an owner should be reminded of a service.
+// Trigger is either "sale" or "workcard" and Months is the time that has to
+// pass since the trigger (or the previous reminder) before a reminder is due.
+type ReminderRule struct {
+	Id      int    `json:"id"`
+	Name    string `json:"name"`
+	Trigger string `json:"trigger"`
+	Months  int    `json:"months"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	Active  bool   `json:"active"`
+}
+
+type Reminder struct {
+	Id          int       `json:"id"`
+	RuleId      int       `json:"ruleId"`
+	FrameNumber string    `json:"frameNumber"`
+	CustomerId  int       `json:"customerId"`
+	Email       string    `json:"email"`
+	SentAt      time.Time `json:"sentAt"`
+}
+
+// DueReminder is a bike whose owner should receive a reminder for a rule.
+type DueReminder struct {
+	Rule        ReminderRule
+	FrameNumber string
+	Owner       Customer
+	LastEvent   time.Time
+}
+
+type Mechanic struct {
+	Id    int            `json:"id"`
+	Name  string         `json:"name"`
+	Hours []WorkingHours `json:"hours"`
+}
+
+// WorkingHours is the time of day a mechanic works on a weekday (0 = Sunday).
+// Start and End are formatted as "15:04".
+type WorkingHours struct {
+	Weekday time.Weekday `json:"weekday"`
+	Start   string       `json:"start"`
+	End     string       `json:"end"`
+}
+
+type ServiceType struct {
+	Id       int    `json:"id"`
+	Name     string `json:"name"`
+	Duration int    `json:"duration"`
+}
+
+type Appointment struct {
+	Id            int       `json:"id"`
+	MechanicId    int       `json:"mechanicId"`
+	ServiceTypeId int       `json:"serviceTypeId"`
+	CustomerId    int       `json:"customerId"`
+	FrameNumber   string    `json:"frameNumber"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Status        string    `json:"status"`
+	WorkcardId    *int      `json:"workcardId"`
+}
+
+// Slot is a free time slot with a mechanic for a service type.
+type Slot struct {
+	MechanicId int       `json:"mechanicId"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+}
+
+// TimeEntry is a period a mechanic has worked on a workcard. ClockOut is nil while the mechanic is clocked in.
+type TimeEntry struct {
+	Id         int        `json:"id"`
+	WorkcardId int        `json:"workcardId"`
+	MechanicId int        `json:"mechanicId"`
+	ClockIn    time.Time  `json:"clockIn"`
+	ClockOut   *time.Time `json:"clockOut"`
+}
+
+// WorkcardTime compares the estimated time of a workcard with the time actually spent on it.
+type WorkcardTime struct {
+	WorkcardId       int         `json:"workcardId"`
+	EstimatedMinutes int         `json:"estimatedMinutes"`
+	ActualMinutes    float64     `json:"actualMinutes"`
+	Entries          []TimeEntry `json:"entries"`
+}
+
+// MechanicProductivity summarises the time a mechanic has logged in a period.
+// EstimatedMinutes is the mechanic's share of the estimates of the workcards worked on,
+// and Efficiency is EstimatedMinutes divided by ActualMinutes.
+type MechanicProductivity struct {
+	MechanicId       int     `json:"mechanicId"`
+	Name             string  `json:"name"`
+	Workcards        int     `json:"workcards"`
+	ActualMinutes    float64 `json:"actualMinutes"`
+	EstimatedMinutes float64 `json:"estimatedMinutes"`
+	Efficiency       float64 `json:"efficiency"`
+}
+
+// User is a member of staff who can log in. Password is only used when
+// creating or updating a user and is never returned.
+type User struct {
+	Id       int    `json:"id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Password string `json:"password,omitempty"`
+}
+
+type Session struct {
+	Token   string    `json:"token"`
+	UserId  int       `json:"userId"`
+	Expires time.Time `json:"expires"`
+}
+
+// ApiKey lets a machine client such as the webshop call the routes its scopes allow.
+// Key is only set when the key is issued, afterwards only a hash is stored.
+type ApiKey struct {
+	Id       int        `json:"id"`
+	Name     string     `json:"name"`
+	Prefix   string     `json:"prefix"`
+	Key      string     `json:"key,omitempty"`
+	Scopes   []string   `json:"scopes"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"lastUsed"`
+	Revoked  *time.Time `json:"revoked"`
+}
+
+// AuditEntry records who changed an entity and what it looked like before and after.
+// Before is null for creations and After is null for deletions.
+type AuditEntry struct {
+	Id       int             `json:"id"`
+	Time     time.Time       `json:"time"`
+	Actor    string          `json:"actor"`
+	UserId   *int            `json:"userId"`
+	ApiKeyId *int            `json:"apiKeyId"`
+	Route    string          `json:"route"`
+	Entity   string          `json:"entity"`
+	EntityId string          `json:"entityId"`
+	Before   json.RawMessage `json:"before"`
+	After    json.RawMessage `json:"after"`
+}
+
+// AuditFilter selects audit entries. Empty fields match everything.
+type AuditFilter struct {
+	Entity   string
+	EntityId string
+	UserId   int
+	Limit    int
+}
+
+// ListQuery describes which page of a collection to return. Filters holds the
+// query string parameters other than sort, limit and cursor. Sort is a field name,
+// prefixed with "-" for descending order.
+type ListQuery struct {
+	Filters map[string]string
+	Sort    string
+	Limit   int
+	Cursor  string
+}
+
+// Page is one page of a collection. NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// PriceChange is a price a product has had, has or will have from Effective. Changes made to a
+// product take effect at once, and scheduled changes are Applied once they have taken effect.
+type PriceChange struct {
+	Id        int       `json:"id"`
+	ProductId int       `json:"productId"`
+	Price     float32   `json:"price" validate:"min=0,max=1000000"`
+	Effective time.Time `json:"effective"`
+	Author    string    `json:"author"`
+	Created   time.Time `json:"created"`
+	Applied   bool      `json:"applied"`
+}
+
+// GoodsReceipt is stock of a product received from a manufacturer at UnitCost per item.
+// Without a unit cost the items cost the cost price of the manufacturer for the product.
+type GoodsReceipt struct {
+	ProductId      int      `json:"productId"`
+	ManufacturerId int      `json:"manufacturerId"`
+	Quantity       int      `json:"quantity" validate:"min=1"`
+	UnitCost       *float32 `json:"unitCost" validate:"min=0,max=1000000"`
+}
+
+// InventoryMovement is a change of the stock of a product, such as a goods receipt.
+// AverageCost is the weighted average cost of the product after the movement.
+type InventoryMovement struct {
+	Id             int  `json:"id"`
+	ProductId      int  `json:"productId"`
+	ManufacturerId *int `json:"manufacturerId,omitempty"`
+	// PurchaseOrderLineId is set for goods received against a purchase order
+	PurchaseOrderLineId *int      `json:"purchaseOrderLineId,omitempty"`
+	Quantity            int       `json:"quantity"`
+	UnitCost            *float32  `json:"unitCost"`
+	AverageCost         *float32  `json:"averageCost"`
+	Reason              string    `json:"reason"`
+	Author              string    `json:"author"`
+	Created             time.Time `json:"created"`
+}
+
+// Margin is what is earned on a price. Rate is the share of the price that is profit, and
+// Markup the profit relative to the cost, so a bike costing 600 sold at 1000 has a margin of
+// 0.4 and a markup of 0.667. They are nil while the cost is unknown.
+type Margin struct {
+	Profit *float32 `json:"profit"`
+	Rate   *float32 `json:"margin"`
+	Markup *float32 `json:"markup"`
+}
+
+// ProductCost is what a product costs and earns at its current price: at its weighted
+// average cost, and at the cost price of each of its manufacturers
+type ProductCost struct {
+	ProductId   int      `json:"productId"`
+	Price       float32  `json:"price"`
+	AverageCost *float32 `json:"averageCost"`
+	Margin
+	Manufacturers []ManufacturerCost `json:"manufacturers"`
+}
+
+// ManufacturerCost is the cost price of a product at one of its manufacturers
+type ManufacturerCost struct {
+	ManufacturerId int      `json:"manufacturerId"`
+	Name           string   `json:"name"`
+	CostPrice      *float32 `json:"costPrice" validate:"min=0,max=1000000"`
+	Margin
+}
+
+// SaleMargin is what was earned on a sold bike, at the price and average cost of its product when it was sold
+type SaleMargin struct {
+	FrameNumber string    `json:"frameNumber"`
+	ProductId   int       `json:"productId"`
+	Name        string    `json:"name"`
+	SoldAt      time.Time `json:"soldAt"`
+	Price       float32   `json:"price"`
+	Cost        *float32  `json:"cost"`
+	Margin
+}
+
+// MarginReport sums up the margins of the sales in a period. The totals only count the
+// sales with a known cost, and Unknown is the number of sales without one.
+type MarginReport struct {
+	Sales   []SaleMargin `json:"sales"`
+	Revenue float64      `json:"revenue"`
+	Cost    float64      `json:"cost"`
+	Profit  float64      `json:"profit"`
+	Margin  *float64     `json:"margin"`
+	Unknown int          `json:"unknown"`
+}
+
+// PurchaseOrder is an order of products from a manufacturer. It is a draft until it is sent,
+// and is partially received and then received as the goods arrive, unless it is cancelled.
+// ExpectedDelivery is formatted as YYYY-MM-DD.
+type PurchaseOrder struct {
+	Id               int                 `json:"id"`
+	ManufacturerId   int                 `json:"manufacturerId" validate:"required"`
+	Status           string              `json:"status"`
+	ExpectedDelivery string              `json:"expectedDelivery" validate:"date"`
+	Created          time.Time           `json:"created"`
+	Lines            []PurchaseOrderLine `json:"lines,omitempty"`
+	Version          int                 `json:"version"`
+}
+
+// PurchaseOrderLine is a quantity of a product ordered at UnitCost per item. Without a unit
+// cost the line costs the cost price of the manufacturer for the product.
+type PurchaseOrderLine struct {
+	Id        int      `json:"id"`
+	ProductId int      `json:"productId" validate:"required"`
+	Quantity  int      `json:"quantity" validate:"min=1"`
+	UnitCost  *float32 `json:"unitCost" validate:"min=0,max=1000000"`
+	Received  int      `json:"received"`
+}
+
+// ReceivedLine is a quantity of the products of a purchase order line that has arrived
+type ReceivedLine struct {
+	LineId   int `json:"lineId"`
+	Quantity int `json:"quantity" validate:"min=1"`
+}
+
+// PurchaseOrderReceipt is a purchase order after goods were received against it, with the
+// inventory movements posted for them
+type PurchaseOrderReceipt struct {
+	Order     PurchaseOrder       `json:"order"`
+	Movements []InventoryMovement `json:"movements"`
+}
+
+// CategoryReport sums up a category together with its subcategories. Stock and its value
+// count the variants of the products, and sales are the bikes sold in the reported period.
+type CategoryReport struct {
+	CategoryId int     `json:"categoryId"`
+	Name       string  `json:"name"`
+	ParentId   *int    `json:"parentId"`
+	Products   int     `json:"products"`
+	Stock      int     `json:"stock"`
+	StockValue float64 `json:"stockValue"`
+	BikesSold  int     `json:"bikesSold"`
+	Revenue    float64 `json:"revenue"`
+}
+
+// SearchableProduct is a product with the names of its manufacturers
+type SearchableProduct struct {
+	Product
+	Manufacturers []string `json:"manufacturers"`
+}
+
+// SearchResult is a product found by a search, best match first
+type SearchResult struct {
+	SearchableProduct
+	Score float64 `json:"score"`
+}
+
+// IdempotentResponse is the stored response to a request with an Idempotency-Key,
+// which is replayed when the request is retried
+type IdempotentResponse struct {
+	Status int               `json:"status"`
+	Header map[string]string `json:"header"`
+	Body   []byte            `json:"body"`
+}
+
+// ProductImportRow is a row of an imported price list. Fields that are nil were not in
+// the file and keep their value when an existing product is updated.
+type ProductImportRow struct {
+	Line          int
+	Name          string
+	Price         *float32
+	Size          *string
+	Color         *string
+	Sku           *string
+	Manufacturers []string
+
+	// Errors holds the problems found while reading the row, such as a price that is not a number
+	Errors []string
+}
+
+// ImportOptions controls how imported rows are matched to existing products.
+// Match is the field products are matched on.
+type ImportOptions struct {
+	Match             string
+	DryRun            bool
+	AllowPriceChanges bool
+}
+
+// ImportRowResult tells what the import did, or would do in a dry run, with a row
+type ImportRowResult struct {
+	Line      int      `json:"line"`
+	Action    string   `json:"action"`
+	ProductId int      `json:"productId,omitempty"`
+	Product   *Product `json:"product,omitempty"`
+	Before    *Product `json:"-"`
+	Errors    []string `json:"errors,omitempty"`
+	Linked    []int    `json:"linkedManufacturers,omitempty"`
+}
+
+// ImportResult sums up an import. Nothing is saved in a dry run or when a row has errors.
+type ImportResult struct {
+	DryRun    bool              `json:"dryRun"`
+	Committed bool              `json:"committed"`
+	Created   int               `json:"created"`
+	Updated   int               `json:"updated"`
+	Unchanged int               `json:"unchanged"`
+	Failed    int               `json:"failed"`
+	Rows      []ImportRowResult `json:"rows"`
 }
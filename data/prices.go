@@ -0,0 +1,174 @@
+package data
+
+import (
+	"api/data/models"
+	"api/validation"
+	"context"
+	"database/sql"
+	"time"
+)
+
+const priceChangeColumns = "id, productid, price, effective, author, created, applied"
+
+func scanPriceChange(scanner interface{ Scan(...any) error }, change *models.PriceChange) error {
+	return scanner.Scan(&change.Id, &change.ProductId, &change.Price, &change.Effective, &change.Author, &change.Created, &change.Applied)
+}
+
+// recordPrice adds the current price of the product, and of its variants that follow its price,
+// to the price history as a change made now by the actor of ctx
+func recordPrice(ctx context.Context, tx DBTX, id int) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO pricechanges (productid, price, effective, author, applied) "+
+		"SELECT id, price, now(), $2, true FROM products WHERE id = $1 OR (parentid = $1 AND priceoverride IS NULL)", id, actor(ctx))
+	return err
+}
+
+// lockedPrice returns the price of the product and locks it until the transaction ends,
+// so that the price before a change is known
+func lockedPrice(ctx context.Context, tx DBTX, id int) (float32, error) {
+	var price float32
+	err := tx.QueryRowContext(ctx, "SELECT price FROM products WHERE id = $1 FOR UPDATE", id).Scan(&price)
+	return price, err
+}
+
+// GetPriceHistory returns the price changes of the product, the latest first, including the
+// scheduled ones that have not taken effect yet. It returns sql.ErrNoRows when there is no such product.
+func GetPriceHistory(ctx context.Context, db DBTX, productId int) ([]models.PriceChange, error) {
+	history := []models.PriceChange{}
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)", productId).Scan(&exists)
+	if err != nil {
+		return history, err
+	}
+	if !exists {
+		return history, sql.ErrNoRows
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT "+priceChangeColumns+" FROM pricechanges WHERE productid = $1 ORDER BY effective DESC, id DESC", productId)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var change models.PriceChange
+		if err := scanPriceChange(rows, &change); err != nil {
+			return history, err
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
+
+func GetPriceChange(ctx context.Context, db DBTX, productId int, id int) (models.PriceChange, error) {
+	var change models.PriceChange
+	row := db.QueryRowContext(ctx, "SELECT "+priceChangeColumns+" FROM pricechanges WHERE id = $1 AND productid = $2", id, productId)
+	err := scanPriceChange(row, &change)
+	return change, err
+}
+
+// priceChangeOf gets the price changes of the product by their id alone
+func priceChangeOf(productId int) func(context.Context, DBTX, int) (models.PriceChange, error) {
+	return func(ctx context.Context, db DBTX, id int) (models.PriceChange, error) {
+		return GetPriceChange(ctx, db, productId, id)
+	}
+}
+
+// SchedulePriceChange stores a price change of the product that takes effect at change.Effective,
+// which has to be in the future. The price of a variant becomes its price override.
+func SchedulePriceChange(ctx context.Context, db DBTX, change models.PriceChange) (int, error) {
+	if err := validation.Validate(change); err != nil {
+		return -1, err
+	}
+	if !change.Effective.After(time.Now()) {
+		return -1, invalidf("a scheduled price change has to take effect in the future")
+	}
+	id, err := auditedCreate(ctx, db, "pricechange", priceChangeOf(change.ProductId), func(tx DBTX) (int, error) {
+		var id int
+		err := tx.QueryRowContext(ctx, "INSERT INTO pricechanges (productid, price, effective, author) "+
+			"SELECT id, $2, $3, $4 FROM products WHERE id = $1 RETURNING id",
+			change.ProductId, change.Price, change.Effective, actor(ctx)).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+// CancelPriceChange deletes a scheduled price change that has not taken effect yet
+func CancelPriceChange(ctx context.Context, db DBTX, productId int, id int) error {
+	return auditedDelete(ctx, db, "pricechange", id, priceChangeOf(productId), func(tx DBTX) error {
+		result, err := tx.ExecContext(ctx, "DELETE FROM pricechanges WHERE id = $1 AND productid = $2 AND NOT applied", id, productId)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n > 0 {
+			return err
+		}
+		return invalidf("price change %d has already taken effect", id)
+	})
+}
+
+// ApplyDuePriceChanges changes the prices of the scheduled price changes that have taken effect,
+// in the order they took effect, and returns them. Variants following the price of a changed
+// product get the new price as well, which is recorded in their history at the same time.
+func ApplyDuePriceChanges(ctx context.Context, db DBTX) ([]models.PriceChange, error) {
+	var applied []models.PriceChange
+	err := InTx(ctx, db, func(tx DBTX) error {
+		rows, err := tx.QueryContext(ctx, "SELECT "+priceChangeColumns+" FROM pricechanges "+
+			"WHERE NOT applied AND effective <= now() ORDER BY effective, id FOR UPDATE SKIP LOCKED")
+		if err != nil {
+			return err
+		}
+		var due []models.PriceChange
+		for rows.Next() {
+			var change models.PriceChange
+			if err := scanPriceChange(rows, &change); err != nil {
+				rows.Close()
+				return err
+			}
+			due = append(due, change)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, change := range due {
+			if err := applyPriceChange(ctx, tx, change); err != nil {
+				return err
+			}
+			change.Applied = true
+			applied = append(applied, change)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// applyPriceChange changes the price of the product, which is audited along with the price change
+// as a change made by the actor of ctx, the system when the scheduler applies it
+func applyPriceChange(ctx context.Context, tx DBTX, change models.PriceChange) error {
+	err := auditedUpdate(ctx, tx, "product", change.ProductId, GetProduct, func(tx DBTX) error {
+		var parent sql.NullInt32
+		err := tx.QueryRowContext(ctx, "UPDATE products SET price = $1, "+
+			"priceoverride = CASE WHEN parentid IS NULL THEN priceoverride ELSE $1 END, version = version + 1 "+
+			"WHERE id = $2 RETURNING parentid", change.Price, change.ProductId).Scan(&parent)
+		if err != nil || parent.Valid {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "WITH changed AS ("+
+			"UPDATE products SET price = $1, version = version + 1 "+
+			"WHERE parentid = $2 AND priceoverride IS NULL AND price <> $1 RETURNING id, price) "+
+			"INSERT INTO pricechanges (productid, price, effective, author, applied) "+
+			"SELECT id, price, $3, $4, true FROM changed", change.Price, change.ProductId, change.Effective, change.Author)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return auditedUpdate(ctx, tx, "pricechange", change.Id, priceChangeOf(change.ProductId), func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, "UPDATE pricechanges SET applied = true WHERE id = $1", change.Id)
+		return err
+	})
+}
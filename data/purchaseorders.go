@@ -0,0 +1,295 @@
+package data
+
+import (
+	"api/data/models"
+	"api/validation"
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+)
+
+// Statuses of a purchase order
+const (
+	OrderDraft             = "draft"
+	OrderSent              = "sent"
+	OrderPartiallyReceived = "partiallyreceived"
+	OrderReceived          = "received"
+	OrderCancelled         = "cancelled"
+)
+
+const purchaseOrderColumns = "id, manufacturerid, status, COALESCE(to_char(expecteddelivery, 'YYYY-MM-DD'), ''), created, version"
+
+func purchaseOrderDest(order *models.PurchaseOrder) []any {
+	return []any{&order.Id, &order.ManufacturerId, &order.Status, &order.ExpectedDelivery, &order.Created, &order.Version}
+}
+
+// Methods for CRUD operations on purchase orders
+
+// CreatePurchaseOrder creates a draft purchase order. Every product has to be supplied by the manufacturer.
+func CreatePurchaseOrder(ctx context.Context, db DBTX, order models.PurchaseOrder) (int, error) {
+	if err := validation.Validate(order); err != nil {
+		return -1, err
+	}
+	id, err := auditedCreate(ctx, db, "purchaseorder", GetPurchaseOrder, func(tx DBTX) (int, error) {
+		var id int
+		err := tx.QueryRowContext(ctx, "INSERT INTO purchaseorders (manufacturerid, expecteddelivery) VALUES ($1, NULLIF($2, '')::date) RETURNING id",
+			order.ManufacturerId, order.ExpectedDelivery).Scan(&id)
+		if err != nil {
+			return id, err
+		}
+		return id, insertPurchaseOrderLines(ctx, tx, id, order.ManufacturerId, order.Lines)
+	})
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func insertPurchaseOrderLines(ctx context.Context, tx DBTX, id int, manufacturer int, lines []models.PurchaseOrderLine) error {
+	for _, line := range lines {
+		var costPrice *float32
+		err := tx.QueryRowContext(ctx, "SELECT costprice FROM productsmanufacturers WHERE productid = $1 AND manufacturerid = $2",
+			line.ProductId, manufacturer).Scan(&costPrice)
+		if errors.Is(err, sql.ErrNoRows) {
+			return invalidf("product %d is not supplied by manufacturer %d", line.ProductId, manufacturer)
+		}
+		if err != nil {
+			return err
+		}
+		if line.UnitCost == nil {
+			line.UnitCost = costPrice
+		}
+		if line.UnitCost == nil {
+			return invalidf("the line of product %d has no unit cost, and manufacturer %d has no cost price for it", line.ProductId, manufacturer)
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO purchaseorderlines (purchaseorderid, productid, quantity, unitcost) VALUES ($1, $2, $3, $4)",
+			id, line.ProductId, line.Quantity, *line.UnitCost)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPurchaseOrder returns the purchase order with its lines
+func GetPurchaseOrder(ctx context.Context, db DBTX, id int) (models.PurchaseOrder, error) {
+	var order models.PurchaseOrder
+	err := db.QueryRowContext(ctx, "SELECT "+purchaseOrderColumns+" FROM purchaseorders WHERE id = $1", id).Scan(purchaseOrderDest(&order)...)
+	if err != nil {
+		return order, err
+	}
+
+	order.Lines = []models.PurchaseOrderLine{}
+	rows, err := db.QueryContext(ctx, "SELECT id, productid, quantity, unitcost, received FROM purchaseorderlines WHERE purchaseorderid = $1 ORDER BY id", id)
+	if err != nil {
+		return order, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line models.PurchaseOrderLine
+		if err := rows.Scan(&line.Id, &line.ProductId, &line.Quantity, &line.UnitCost, &line.Received); err != nil {
+			return order, err
+		}
+		order.Lines = append(order.Lines, line)
+	}
+	return order, rows.Err()
+}
+
+var purchaseOrderList = listSpec{
+	from: "purchaseorders",
+	key:  "id",
+	filters: map[string]string{
+		"manufacturer": "manufacturerid = ?",
+		"status":       "status = ?",
+		"product":      "id IN (SELECT purchaseorderid FROM purchaseorderlines WHERE productid = ?)",
+	},
+	sorts: map[string]string{
+		"id":               "id",
+		"created":          "created",
+		"expectedDelivery": "COALESCE(expecteddelivery, 'infinity')",
+	},
+	defaultSort: "id",
+}
+
+// GetPurchaseOrders returns a page of the purchase orders matching the filters of the query, without their lines
+func GetPurchaseOrders(ctx context.Context, db DBTX, q models.ListQuery) (models.Page[models.PurchaseOrder], error) {
+	return listPage(ctx, db, purchaseOrderList, purchaseOrderColumns, q, purchaseOrderDest)
+}
+
+// lockedOrderStatus returns the status of the purchase order and locks it until the transaction ends
+func lockedOrderStatus(ctx context.Context, tx DBTX, id int) (string, int, error) {
+	var status string
+	var manufacturer int
+	err := tx.QueryRowContext(ctx, "SELECT status, manufacturerid FROM purchaseorders WHERE id = $1 FOR UPDATE", id).Scan(&status, &manufacturer)
+	return status, manufacturer, err
+}
+
+// lockedPurchaseOrder returns the purchase order with its lines and locks it until the transaction ends
+func lockedPurchaseOrder(ctx context.Context, tx DBTX, id int) (models.PurchaseOrder, error) {
+	if _, _, err := lockedOrderStatus(ctx, tx, id); err != nil {
+		return models.PurchaseOrder{}, err
+	}
+	return GetPurchaseOrder(ctx, tx, id)
+}
+
+// UpdatePurchaseOrder replaces the manufacturer, expected delivery and lines of a draft purchase order
+// if it is still at order.Version, and returns its new version
+func UpdatePurchaseOrder(ctx context.Context, db DBTX, order models.PurchaseOrder) (int, error) {
+	if err := validation.Validate(order); err != nil {
+		return -1, err
+	}
+	var version int
+	err := auditedUpdate(ctx, db, "purchaseorder", order.Id, lockedPurchaseOrder, func(tx DBTX) error {
+		status, _, err := lockedOrderStatus(ctx, tx, order.Id)
+		if err != nil {
+			return err
+		}
+		if status != OrderDraft {
+			return invalidf("purchase order %d has been %s and can no longer be changed", order.Id, status)
+		}
+		err = tx.QueryRowContext(ctx, "UPDATE purchaseorders SET manufacturerid = $1, expecteddelivery = NULLIF($2, '')::date, version = version + 1 "+
+			"WHERE id = $3 AND version = $4 RETURNING version", order.ManufacturerId, order.ExpectedDelivery, order.Id, order.Version).Scan(&version)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrVersionConflict
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM purchaseorderlines WHERE purchaseorderid = $1", order.Id); err != nil {
+			return err
+		}
+		return insertPurchaseOrderLines(ctx, tx, order.Id, order.ManufacturerId, order.Lines)
+	})
+	if err != nil {
+		return -1, err
+	}
+	return version, nil
+}
+
+// DeletePurchaseOrder deletes a draft purchase order if it is still at the given version.
+// Orders that have been sent are cancelled instead.
+func DeletePurchaseOrder(ctx context.Context, db DBTX, id int, version int) error {
+	return auditedDelete(ctx, db, "purchaseorder", id, lockedPurchaseOrder, func(tx DBTX) error {
+		status, _, err := lockedOrderStatus(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if status != OrderDraft {
+			return invalidf("purchase order %d has been %s and can only be cancelled", id, status)
+		}
+		return versionedExec(ctx, tx, "purchaseorders", "id", id, "DELETE FROM purchaseorders WHERE id = $1 AND version = $2", id, version)
+	})
+}
+
+// SendPurchaseOrder marks a draft purchase order with at least one line as sent to the manufacturer
+func SendPurchaseOrder(ctx context.Context, db DBTX, id int) error {
+	return auditedUpdate(ctx, db, "purchaseorder", id, lockedPurchaseOrder, func(tx DBTX) error {
+		status, _, err := lockedOrderStatus(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if status != OrderDraft {
+			return invalidf("purchase order %d has already been %s", id, status)
+		}
+		var lines int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM purchaseorderlines WHERE purchaseorderid = $1", id).Scan(&lines); err != nil {
+			return err
+		}
+		if lines == 0 {
+			return invalidf("purchase order %d has no lines", id)
+		}
+		return setOrderStatus(ctx, tx, id, OrderSent)
+	})
+}
+
+// CancelPurchaseOrder cancels a purchase order that has not been received in full.
+// Goods already received against it stay in stock.
+func CancelPurchaseOrder(ctx context.Context, db DBTX, id int) error {
+	return auditedUpdate(ctx, db, "purchaseorder", id, lockedPurchaseOrder, func(tx DBTX) error {
+		status, _, err := lockedOrderStatus(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if status == OrderReceived || status == OrderCancelled {
+			return invalidf("purchase order %d has already been %s", id, status)
+		}
+		return setOrderStatus(ctx, tx, id, OrderCancelled)
+	})
+}
+
+func setOrderStatus(ctx context.Context, tx DBTX, id int, status string) error {
+	_, err := tx.ExecContext(ctx, "UPDATE purchaseorders SET status = $1, version = version + 1 WHERE id = $2", status, id)
+	return err
+}
+
+// ReceivePurchaseOrder receives goods against the lines of a sent purchase order. Each received
+// quantity is posted as an inventory movement at the unit cost of its line, which updates the stock
+// and average cost of the product. The order is received once all of its lines are, and partially
+// received until then. No more can be received on a line than is still outstanding.
+func ReceivePurchaseOrder(ctx context.Context, db DBTX, id int, received []models.ReceivedLine) (models.PurchaseOrderReceipt, error) {
+	receipt := models.PurchaseOrderReceipt{Movements: []models.InventoryMovement{}}
+	if len(received) == 0 {
+		return receipt, invalidf("nothing was received")
+	}
+	for _, r := range received {
+		if err := validation.Validate(r); err != nil {
+			return receipt, err
+		}
+	}
+	err := auditedUpdate(ctx, db, "purchaseorder", id, lockedPurchaseOrder, func(tx DBTX) error {
+		status, manufacturer, err := lockedOrderStatus(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains([]string{OrderSent, OrderPartiallyReceived}, status) {
+			return invalidf("purchase order %d is %s, goods can only be received once it has been sent", id, status)
+		}
+
+		for _, r := range received {
+			var product, outstanding int
+			var unitCost float32
+			err := tx.QueryRowContext(ctx, "SELECT productid, quantity - received, unitcost FROM purchaseorderlines WHERE id = $1 AND purchaseorderid = $2",
+				r.LineId, id).Scan(&product, &outstanding, &unitCost)
+			if errors.Is(err, sql.ErrNoRows) {
+				return invalidf("purchase order %d has no line %d", id, r.LineId)
+			}
+			if err != nil {
+				return err
+			}
+			if r.Quantity > outstanding {
+				return invalidf("line %d has only %d items outstanding", r.LineId, outstanding)
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE purchaseorderlines SET received = received + $1 WHERE id = $2", r.Quantity, r.LineId); err != nil {
+				return err
+			}
+			movement, err := receiveGoods(ctx, tx, models.GoodsReceipt{
+				ProductId:      product,
+				ManufacturerId: manufacturer,
+				Quantity:       r.Quantity,
+				UnitCost:       &unitCost,
+			}, &r.LineId)
+			if err != nil {
+				return err
+			}
+			receipt.Movements = append(receipt.Movements, movement)
+		}
+
+		var complete bool
+		err = tx.QueryRowContext(ctx, "SELECT bool_and(received = quantity) FROM purchaseorderlines WHERE purchaseorderid = $1", id).Scan(&complete)
+		if err != nil {
+			return err
+		}
+		status = OrderPartiallyReceived
+		if complete {
+			status = OrderReceived
+		}
+		if err := setOrderStatus(ctx, tx, id, status); err != nil {
+			return err
+		}
+		receipt.Order, err = GetPurchaseOrder(ctx, tx, id)
+		return err
+	})
+	return receipt, err
+}
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"api/data/models"
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Methods for performing CRUD on the reminderrules table
+func CreateReminderRule(ctx context.Context, db DBTX, rule models.ReminderRule) (int, error) {
+	id, err := auditedCreate(ctx, db, "reminderrule", GetReminderRule, func(tx DBTX) (int, error) {
+		var id int
+		err := tx.QueryRowContext(ctx, "INSERT INTO reminderrules (name, trigger, months, subject, body, active) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;",
+			rule.Name, rule.Trigger, rule.Months, rule.Subject, rule.Body, rule.Active).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func GetReminderRule(ctx context.Context, db DBTX, id int) (models.ReminderRule, error) {
+	var rule models.ReminderRule
+	row := db.QueryRowContext(ctx, "SELECT id, name, trigger, months, subject, body, active FROM reminderrules WHERE id = $1", id)
+	if row.Err() != nil {
+		return rule, row.Err()
+	}
+	err := row.Scan(&rule.Id, &rule.Name, &rule.Trigger, &rule.Months, &rule.Subject, &rule.Body, &rule.Active)
+	if err != nil {
+		return rule, err
+	}
+	return rule, nil
+}
+
+func GetReminderRules(ctx context.Context, db DBTX) ([]models.ReminderRule, error) {
+	var rules []models.ReminderRule
+	rows, err := db.QueryContext(ctx, "SELECT id, name, trigger, months, subject, body, active FROM reminderrules ORDER BY id")
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rule models.ReminderRule
+		err := rows.Scan(&rule.Id, &rule.Name, &rule.Trigger, &rule.Months, &rule.Subject, &rule.Body, &rule.Active)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func UpdateReminderRule(ctx context.Context, db DBTX, rule models.ReminderRule) error {
+	return auditedUpdate(ctx, db, "reminderrule", rule.Id, GetReminderRule, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, "UPDATE reminderrules "+
+			"SET name = $1, trigger = $2, months = $3, subject = $4, body = $5, active = $6 WHERE id = $7;",
+			rule.Name, rule.Trigger, rule.Months, rule.Subject, rule.Body, rule.Active, rule.Id)
+		return err
+	})
+}
+
+func DeleteReminderRule(ctx context.Context, db DBTX, id int) error {
+	return auditedDelete(ctx, db, "reminderrule", id, GetReminderRule, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM reminderrules WHERE id = $1", id)
+		return err
+	})
+}
+
+// GetDueReminders finds the bikes with an owner that are due a reminder for the given rule.
+// The reminder is due when the rule's number of months has passed since the latest of the
+// trigger event (the sale or the last workcard) and the last reminder sent for the rule.
+func GetDueReminders(ctx context.Context, db DBTX, rule models.ReminderRule) ([]models.DueReminder, error) {
+	var due []models.DueReminder
+
+	var event string
+	switch rule.Trigger {
+	case "sale":
+		event = "b.soldat"
+	case "workcard":
+		event = "(SELECT MAX(w.created) FROM workcards w WHERE w.framenumber = b.framenumber)"
+	default:
+		return due, invalidf("unknown reminder trigger: %s", rule.Trigger)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT framenumber, id, firstname, lastname, phonenumber, email, lastevent FROM ("+
+		"SELECT b.framenumber, c.id, c.firstname, c.lastname, c.phonenumber, c.email, "+event+" AS lastevent, "+
+		"(SELECT MAX(r.sentat) FROM reminders r WHERE r.ruleid = $1 AND r.framenumber = b.framenumber) AS lastsent "+
+		"FROM bikes b JOIN customers c ON c.id = b.owner) due "+
+		"WHERE lastevent IS NOT NULL AND GREATEST(lastevent, lastsent) <= now() - make_interval(months => $2)",
+		rule.Id, rule.Months)
+	if err != nil {
+		return due, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d := models.DueReminder{Rule: rule}
+		err := rows.Scan(&d.FrameNumber, &d.Owner.Id, &d.Owner.FirstName, &d.Owner.LastName, &d.Owner.Phone, &d.Owner.Email, &d.LastEvent)
+		if err != nil {
+			return due, err
+		}
+		due = append(due, d)
+	}
+	return due, nil
+}
+
+// ClaimReminder locks the bike of a due reminder for the rest of the transaction tx, so that
+// the reminder is only sent once when the scheduler and a manual run overlap. It returns false
+// when another run holds the bike or has already sent the reminder.
+func ClaimReminder(ctx context.Context, tx DBTX, rule models.ReminderRule, frameNumber string) (bool, error) {
+	var claimed string
+	err := tx.QueryRowContext(ctx, "SELECT framenumber FROM bikes b WHERE framenumber = $1 AND NOT EXISTS ("+
+		"SELECT 1 FROM reminders r WHERE r.ruleid = $2 AND r.framenumber = b.framenumber AND r.sentat > now() - make_interval(months => $3)) "+
+		"FOR UPDATE SKIP LOCKED", frameNumber, rule.Id, rule.Months).Scan(&claimed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Methods for the reminders table, which logs the reminders that have been sent
+func CreateReminder(ctx context.Context, db DBTX, reminder models.Reminder) (int, error) {
+	row := db.QueryRowContext(ctx, "INSERT INTO reminders (ruleid, framenumber, customerid, email) VALUES ($1, $2, $3, $4) RETURNING id;",
+		reminder.RuleId, reminder.FrameNumber, reminder.CustomerId, reminder.Email)
+	if row.Err() != nil {
+		return -1, row.Err()
+	}
+	var id int
+	err := row.Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func GetReminders(ctx context.Context, db DBTX) ([]models.Reminder, error) {
+	var reminders []models.Reminder
+	rows, err := db.QueryContext(ctx, "SELECT id, ruleid, framenumber, customerid, email, sentat FROM reminders ORDER BY sentat DESC")
+	if err != nil {
+		return reminders, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reminder models.Reminder
+		err := rows.Scan(&reminder.Id, &reminder.RuleId, &reminder.FrameNumber, &reminder.CustomerId, &reminder.Email, &reminder.SentAt)
+		if err != nil {
+			return reminders, err
+		}
+		reminders = append(reminders, reminder)
+	}
+	return reminders, nil
+}
@@ -0,0 +1,217 @@
+package data
+
+import (
+	"context"
+	"slices"
+)
+
+// Roles a staff user can have. The owner always has every permission
+// so the shop can never be locked out of its own system.
+const (
+	RoleOwner    = "owner"
+	RoleManager  = "manager"
+	RoleCashier  = "cashier"
+	RoleMechanic = "mechanic"
+)
+
+var Roles = []string{RoleOwner, RoleManager, RoleCashier, RoleMechanic}
+
+// Permissions guarding the routes. Each route in addRoutes requires one of them.
+const (
+	PermProductsRead        = "products.read"
+	PermProductsWrite       = "products.write"
+	PermProductsPrice       = "products.price"
+	PermProductsDelete      = "products.delete"
+	PermCostsRead           = "costs.read"
+	PermCostsWrite          = "costs.write"
+	PermPurchasingRead      = "purchasing.read"
+	PermPurchasingManage    = "purchasing.manage"
+	PermInventoryReceive    = "inventory.receive"
+	PermCustomersRead       = "customers.read"
+	PermCustomersWrite      = "customers.write"
+	PermCustomersDelete     = "customers.delete"
+	PermManufacturersRead   = "manufacturers.read"
+	PermManufacturersWrite  = "manufacturers.write"
+	PermManufacturersDelete = "manufacturers.delete"
+	PermBikesRead           = "bikes.read"
+	PermBikesWrite          = "bikes.write"
+	PermBikesDelete         = "bikes.delete"
+	PermWorkcardsRead       = "workcards.read"
+	PermWorkcardsWrite      = "workcards.write"
+	PermTimeTrack           = "time.track"
+	PermAppointmentsRead    = "appointments.read"
+	PermAppointmentsWrite   = "appointments.write"
+	PermWorkshopManage      = "workshop.manage"
+	PermRemindersManage     = "reminders.manage"
+	PermReportsRead         = "reports.read"
+	PermUsersManage         = "users.manage"
+	PermRolesManage         = "roles.manage"
+	PermApiKeysManage       = "apikeys.manage"
+	PermAuditRead           = "audit.read"
+)
+
+var Permissions = []string{
+	PermProductsRead, PermProductsWrite, PermProductsPrice, PermProductsDelete,
+	PermCostsRead, PermCostsWrite,
+	PermPurchasingRead, PermPurchasingManage, PermInventoryReceive,
+	PermCustomersRead, PermCustomersWrite, PermCustomersDelete,
+	PermManufacturersRead, PermManufacturersWrite, PermManufacturersDelete,
+	PermBikesRead, PermBikesWrite, PermBikesDelete,
+	PermWorkcardsRead, PermWorkcardsWrite, PermTimeTrack,
+	PermAppointmentsRead, PermAppointmentsWrite, PermWorkshopManage,
+	PermRemindersManage, PermReportsRead, PermUsersManage, PermRolesManage,
+	PermApiKeysManage, PermAuditRead,
+}
+
+// defaultPermissions are the permissions given to the roles when the database is set up
+var defaultPermissions = map[string][]string{
+	RoleManager: {
+		PermProductsRead, PermProductsWrite, PermProductsPrice, PermProductsDelete,
+		PermCostsRead, PermCostsWrite,
+		PermPurchasingRead, PermPurchasingManage, PermInventoryReceive,
+		PermCustomersRead, PermCustomersWrite, PermCustomersDelete,
+		PermManufacturersRead, PermManufacturersWrite, PermManufacturersDelete,
+		PermBikesRead, PermBikesWrite, PermBikesDelete,
+		PermWorkcardsRead, PermWorkcardsWrite, PermTimeTrack,
+		PermAppointmentsRead, PermAppointmentsWrite, PermWorkshopManage,
+		PermRemindersManage, PermReportsRead, PermAuditRead,
+	},
+	RoleCashier: {
+		PermProductsRead, PermProductsWrite,
+		PermCustomersRead, PermCustomersWrite,
+		PermManufacturersRead,
+		PermBikesRead, PermBikesWrite,
+		PermWorkcardsRead, PermWorkcardsWrite,
+		PermAppointmentsRead, PermAppointmentsWrite,
+	},
+	RoleMechanic: {
+		PermProductsRead, PermCustomersRead, PermBikesRead,
+		PermWorkcardsRead, PermWorkcardsWrite, PermTimeTrack,
+		PermAppointmentsRead,
+	},
+}
+
+// seedRolePermissions gives the roles their default permissions the first time the database is set up
+func seedRolePermissions(ctx context.Context, db DBTX) error {
+	var count int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM rolepermissions").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	for role, permissions := range defaultPermissions {
+		if err := SetRolePermissions(ctx, db, role, permissions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitPermissions are permissions that were split off from an older one, which used to guard
+// their routes. Roles and API keys that had the older permission are given the new ones once,
+// when the database is upgraded, so that nobody loses access they had.
+var splitPermissions = []struct{ permission, from string }{
+	{PermCostsRead, PermProductsPrice},
+	// the margin report used to need reports.read
+	{PermCostsRead, PermReportsRead},
+	{PermCostsWrite, PermProductsPrice},
+	{PermPurchasingRead, PermProductsPrice},
+	{PermPurchasingManage, PermProductsPrice},
+	{PermInventoryReceive, PermProductsPrice},
+}
+
+// upgradePermissions grants the split permissions that have not been granted yet
+func upgradePermissions(ctx context.Context, db DBTX) error {
+	for _, split := range splitPermissions {
+		err := InTx(ctx, db, func(tx DBTX) error {
+			res, err := tx.ExecContext(ctx, "INSERT INTO permissionupgrades (permission, frompermission) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+				split.permission, split.from)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil || n == 0 {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, "INSERT INTO rolepermissions (role, permission) SELECT role, $1 FROM rolepermissions "+
+				"WHERE permission = $2 ON CONFLICT DO NOTHING", split.permission, split.from)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, "UPDATE apikeys SET scopes = array_append(scopes, $1) WHERE $2 = ANY(scopes) AND NOT $1 = ANY(scopes)",
+				split.permission, split.from)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRolePermissions returns the permissions of every role
+func GetRolePermissions(ctx context.Context, db DBTX) (map[string][]string, error) {
+	roles := map[string][]string{RoleOwner: Permissions}
+	for _, role := range Roles[1:] {
+		roles[role] = []string{}
+	}
+	rows, err := db.QueryContext(ctx, "SELECT role, permission FROM rolepermissions ORDER BY role, permission")
+	if err != nil {
+		return roles, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role, permission string
+		if err := rows.Scan(&role, &permission); err != nil {
+			return roles, err
+		}
+		if role != RoleOwner {
+			roles[role] = append(roles[role], permission)
+		}
+	}
+	return roles, nil
+}
+
+// SetRolePermissions replaces the permissions of a role
+func SetRolePermissions(ctx context.Context, db DBTX, role string, permissions []string) error {
+	if role == RoleOwner {
+		return invalidf("the permissions of the %s role cannot be changed", RoleOwner)
+	}
+	if !slices.Contains(Roles, role) {
+		return invalidf("unknown role: %s", role)
+	}
+	for _, permission := range permissions {
+		if !slices.Contains(Permissions, permission) {
+			return invalidf("unknown permission: %s", permission)
+		}
+	}
+
+	return InTx(ctx, db, func(tx DBTX) error {
+		roles, err := GetRolePermissions(ctx, tx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM rolepermissions WHERE role = $1", role)
+		if err != nil {
+			return err
+		}
+		for _, permission := range permissions {
+			_, err = tx.ExecContext(ctx, "INSERT INTO rolepermissions (role, permission) VALUES ($1, $2) ON CONFLICT DO NOTHING", role, permission)
+			if err != nil {
+				return err
+			}
+		}
+		return audit(ctx, tx, "role", role, roles[role], permissions)
+	})
+}
+
+// HasPermission reports whether the role has been given the permission
+func HasPermission(ctx context.Context, db DBTX, role string, permission string) (bool, error) {
+	if role == RoleOwner {
+		return true, nil
+	}
+	var ok bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM rolepermissions WHERE role = $1 AND permission = $2)", role, permission).Scan(&ok)
+	return ok, err
+}
@@ -0,0 +1,44 @@
+package data
+
+import (
+	"api/data/models"
+	"context"
+
+	"github.com/lib/pq"
+)
+
+const searchableProductQuery = "SELECT p.id, p.name, p.price, COALESCE(p.size, ''), COALESCE(p.color, ''), COALESCE(p.sku, ''), p.version, " +
+	"COALESCE(array_agg(m.name ORDER BY m.name) FILTER (WHERE m.id IS NOT NULL), '{}') " +
+	"FROM products p " +
+	"LEFT JOIN productsmanufacturers pm ON pm.productid = p.id " +
+	"LEFT JOIN manufacturers m ON m.id = pm.manufacturerid "
+
+// GetSearchableProducts returns every product with the names of its manufacturers,
+// which is what the search index is built from
+func GetSearchableProducts(ctx context.Context, db DBTX) ([]models.SearchableProduct, error) {
+	return querySearchableProducts(ctx, db, searchableProductQuery+"GROUP BY p.id ORDER BY p.id")
+}
+
+// GetSearchableProductsByIds returns the products with the given ids and the names of their manufacturers
+func GetSearchableProductsByIds(ctx context.Context, db DBTX, ids []int) ([]models.SearchableProduct, error) {
+	return querySearchableProducts(ctx, db, searchableProductQuery+"WHERE p.id = ANY($1) GROUP BY p.id ORDER BY p.id", pq.Array(ids))
+}
+
+func querySearchableProducts(ctx context.Context, db DBTX, query string, args ...any) ([]models.SearchableProduct, error) {
+	var products []models.SearchableProduct
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return products, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.SearchableProduct
+		err := rows.Scan(&p.Id, &p.Name, &p.Price, &p.Size, &p.Color, &p.Sku, &p.Version, pq.Array(&p.Manufacturers))
+		if err != nil {
+			return products, err
+		}
+		products = append(products, p)
+	}
+	return products, rows.Err()
+}
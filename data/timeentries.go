@@ -0,0 +1,144 @@
+package data
+
+import (
+	"api/data/models"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrClockedIn    = errors.New("the mechanic is already clocked in")
+	ErrNotClockedIn = errors.New("the mechanic is not clocked in on the workcard")
+)
+
+// ClockIn starts a time entry for the mechanic on the workcard.
+// A mechanic can only be clocked in on one workcard at a time.
+func ClockIn(ctx context.Context, db DBTX, workcardId int, mechanicId int) (models.TimeEntry, error) {
+	var entry models.TimeEntry
+	err := InTx(ctx, db, func(tx DBTX) error {
+		var clockedIn bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM timeentries WHERE mechanicid = $1 AND clockout IS NULL)", mechanicId).Scan(&clockedIn)
+		if err != nil {
+			return err
+		}
+		if clockedIn {
+			return ErrClockedIn
+		}
+
+		err = tx.QueryRowContext(ctx, "INSERT INTO timeentries (workcardid, mechanicid) VALUES ($1, $2) RETURNING id, workcardid, mechanicid, clockin;",
+			workcardId, mechanicId).Scan(&entry.Id, &entry.WorkcardId, &entry.MechanicId, &entry.ClockIn)
+		if err != nil {
+			return err
+		}
+		return audit(ctx, tx, "timeentry", entry.Id, nil, entry)
+	})
+	return entry, err
+}
+
+// ClockOut ends the open time entry of the mechanic on the workcard
+func ClockOut(ctx context.Context, db DBTX, workcardId int, mechanicId int) (models.TimeEntry, error) {
+	var entry models.TimeEntry
+	err := InTx(ctx, db, func(tx DBTX) error {
+		var clockOut time.Time
+		err := tx.QueryRowContext(ctx, "UPDATE timeentries SET clockout = now() WHERE workcardid = $1 AND mechanicid = $2 AND clockout IS NULL "+
+			"RETURNING id, workcardid, mechanicid, clockin, clockout;", workcardId, mechanicId).
+			Scan(&entry.Id, &entry.WorkcardId, &entry.MechanicId, &entry.ClockIn, &clockOut)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotClockedIn
+		}
+		if err != nil {
+			return err
+		}
+		before := entry
+		entry.ClockOut = &clockOut
+		return audit(ctx, tx, "timeentry", entry.Id, before, entry)
+	})
+	return entry, err
+}
+
+func GetTimeEntries(ctx context.Context, db DBTX, workcardId int) ([]models.TimeEntry, error) {
+	entries := []models.TimeEntry{}
+	rows, err := db.QueryContext(ctx, "SELECT id, workcardid, mechanicid, clockin, clockout FROM timeentries WHERE workcardid = $1 ORDER BY clockin", workcardId)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry models.TimeEntry
+		var clockOut sql.NullTime
+		err := rows.Scan(&entry.Id, &entry.WorkcardId, &entry.MechanicId, &entry.ClockIn, &clockOut)
+		if err != nil {
+			return entries, err
+		}
+		if clockOut.Valid {
+			entry.ClockOut = &clockOut.Time
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// GetWorkcardTime returns the time entries of a workcard along with the estimated and actual time.
+// Entries that are still open count until now.
+func GetWorkcardTime(ctx context.Context, db DBTX, workcardId int) (models.WorkcardTime, error) {
+	workcardTime := models.WorkcardTime{WorkcardId: workcardId}
+	workcard, err := GetWorkcard(ctx, db, workcardId)
+	if err != nil {
+		return workcardTime, err
+	}
+	workcardTime.EstimatedMinutes = *workcard.EstimatedMinutes
+
+	workcardTime.Entries, err = GetTimeEntries(ctx, db, workcardId)
+	if err != nil {
+		return workcardTime, err
+	}
+	now := time.Now()
+	for _, entry := range workcardTime.Entries {
+		end := now
+		if entry.ClockOut != nil {
+			end = *entry.ClockOut
+		}
+		workcardTime.ActualMinutes += end.Sub(entry.ClockIn).Minutes()
+	}
+	return workcardTime, nil
+}
+
+// GetProductivity reports the time each mechanic has logged within [from, to).
+// Entries crossing the range are cut at its edges, and the estimate of a workcard
+// is shared between the mechanics in proportion to the time they spent on it.
+func GetProductivity(ctx context.Context, db DBTX, from time.Time, to time.Time) ([]models.MechanicProductivity, error) {
+	report := []models.MechanicProductivity{}
+	rows, err := db.QueryContext(ctx, "WITH entries AS ("+
+		"SELECT mechanicid, workcardid, "+
+		"EXTRACT(EPOCH FROM LEAST(COALESCE(clockout, now()), $2::timestamptz) - GREATEST(clockin, $1::timestamptz)) / 60 AS minutes "+
+		"FROM timeentries WHERE clockin < $2 AND COALESCE(clockout, now()) > $1), "+
+		"totals AS ("+
+		"SELECT workcardid, EXTRACT(EPOCH FROM SUM(COALESCE(clockout, now()) - clockin)) / 60 AS minutes "+
+		"FROM timeentries GROUP BY workcardid) "+
+		"SELECT m.id, m.name, COUNT(DISTINCT e.workcardid), SUM(e.minutes), "+
+		"COALESCE(SUM(w.estimatedminutes * e.minutes / NULLIF(t.minutes, 0)), 0) "+
+		"FROM entries e JOIN mechanics m ON m.id = e.mechanicid "+
+		"JOIN workcards w ON w.id = e.workcardid "+
+		"JOIN totals t ON t.workcardid = e.workcardid "+
+		"GROUP BY m.id, m.name ORDER BY m.id", from, to)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.MechanicProductivity
+		err := rows.Scan(&p.MechanicId, &p.Name, &p.Workcards, &p.ActualMinutes, &p.EstimatedMinutes)
+		if err != nil {
+			return report, err
+		}
+		if p.ActualMinutes > 0 {
+			p.Efficiency = p.EstimatedMinutes / p.ActualMinutes
+		}
+		report = append(report, p)
+	}
+	return report, nil
+}
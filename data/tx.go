@@ -0,0 +1,40 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// DBTX is a database or a transaction. The data functions take a DBTX, so that they can
+// run on their own or together in a transaction started with InTx.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// InTx runs fn as a unit of work in a transaction, which is committed when fn returns nil
+// and rolled back otherwise. When db is already a transaction fn joins it, so functions
+// using InTx can be composed into a larger unit of work that commits or fails as a whole.
+func InTx(ctx context.Context, db DBTX, fn func(tx DBTX) error) error {
+	switch conn := db.(type) {
+	case *sql.Tx:
+		return fn(conn)
+	case interface {
+		BeginTx(context.Context, *sql.TxOptions) (*sql.Tx, error)
+	}:
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	default:
+		return fmt.Errorf("cannot start a transaction on %T", db)
+	}
+}
@@ -0,0 +1,179 @@
+package data
+
+import (
+	"api/data/models"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidSession     = errors.New("invalid or expired session")
+)
+
+// SessionDuration is how long a session token is valid after logging in
+const SessionDuration = 12 * time.Hour
+
+// Methods for performing CRUD on the users table
+func CreateUser(ctx context.Context, db DBTX, user models.User) (int, error) {
+	if user.Password == "" {
+		return -1, invalidf("a password is required")
+	}
+	if !slices.Contains(Roles, user.Role) {
+		return -1, invalidf("unknown role: %s", user.Role)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return -1, err
+	}
+	id, err := auditedCreate(ctx, db, "user", GetUser, func(tx DBTX) (int, error) {
+		var id int
+		err := tx.QueryRowContext(ctx, "INSERT INTO users (username, name, role, passwordhash) VALUES ($1, $2, $3, $4) RETURNING id;",
+			user.Username, user.Name, user.Role, string(hash)).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func GetUser(ctx context.Context, db DBTX, id int) (models.User, error) {
+	var user models.User
+	row := db.QueryRowContext(ctx, "SELECT id, username, name, role FROM users WHERE id = $1", id)
+	if row.Err() != nil {
+		return user, row.Err()
+	}
+	err := row.Scan(&user.Id, &user.Username, &user.Name, &user.Role)
+	if err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+func GetUsers(ctx context.Context, db DBTX) ([]models.User, error) {
+	var users []models.User
+	rows, err := db.QueryContext(ctx, "SELECT id, username, name, role FROM users ORDER BY id")
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user models.User
+		err := rows.Scan(&user.Id, &user.Username, &user.Name, &user.Role)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// UpdateUser updates the username, name and role of a user, and the password if one is given.
+// Changing the password logs the user out everywhere.
+func UpdateUser(ctx context.Context, db DBTX, user models.User) error {
+	if !slices.Contains(Roles, user.Role) {
+		return invalidf("unknown role: %s", user.Role)
+	}
+	var hash []byte
+	if user.Password != "" {
+		var err error
+		if hash, err = bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost); err != nil {
+			return err
+		}
+	}
+	return auditedUpdate(ctx, db, "user", user.Id, GetUser, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, "UPDATE users SET username = $1, name = $2, role = $3 WHERE id = $4;", user.Username, user.Name, user.Role, user.Id)
+		if err != nil || hash == nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE users SET passwordhash = $1 WHERE id = $2;", string(hash), user.Id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM sessions WHERE userid = $1", user.Id)
+		return err
+	})
+}
+
+func DeleteUser(ctx context.Context, db DBTX, id int) error {
+	return auditedDelete(ctx, db, "user", id, GetUser, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
+		return err
+	})
+}
+
+func CountUsers(ctx context.Context, db DBTX) (int, error) {
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count)
+	return count, err
+}
+
+// Authenticate checks the password of a user and returns ErrInvalidCredentials if
+// the username is unknown or the password is wrong
+func Authenticate(ctx context.Context, db DBTX, username string, password string) (models.User, error) {
+	var user models.User
+	var hash string
+	err := db.QueryRowContext(ctx, "SELECT id, username, name, role, passwordhash FROM users WHERE username = $1", username).Scan(&user.Id, &user.Username, &user.Name, &user.Role, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrInvalidCredentials
+	}
+	if err != nil {
+		return user, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return user, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// Methods for handling login sessions. Only a hash of the token is stored in the database.
+
+// CreateSession starts a new session for the user and returns the token
+func CreateSession(ctx context.Context, db DBTX, userId int) (models.Session, error) {
+	session := models.Session{UserId: userId, Expires: time.Now().Add(SessionDuration)}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return session, err
+	}
+	session.Token = hex.EncodeToString(b)
+
+	_, err := db.ExecContext(ctx, "INSERT INTO sessions (tokenhash, userid, expires) VALUES ($1, $2, $3)", hashToken(session.Token), userId, session.Expires)
+	if err != nil {
+		return session, err
+	}
+	return session, nil
+}
+
+// GetSessionUser returns the user the session token belongs to, or ErrInvalidSession
+func GetSessionUser(ctx context.Context, db DBTX, token string) (models.User, error) {
+	var user models.User
+	err := db.QueryRowContext(ctx, "SELECT u.id, u.username, u.name, u.role FROM sessions s JOIN users u ON u.id = s.userid "+
+		"WHERE s.tokenhash = $1 AND s.expires > now()", hashToken(token)).Scan(&user.Id, &user.Username, &user.Name, &user.Role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrInvalidSession
+	}
+	return user, err
+}
+
+func DeleteSession(ctx context.Context, db DBTX, token string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM sessions WHERE tokenhash = $1 OR expires <= now()", hashToken(token))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,168 @@
+package data
+
+import (
+	"api/data/models"
+	"api/validation"
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+const variantColumns = "id, parentid, COALESCE(sku, ''), COALESCE(size, ''), COALESCE(color, ''), COALESCE(framesize, ''), COALESCE(wheelsize, ''), " +
+	"ARRAY(SELECT code FROM barcodes WHERE barcodes.productid = products.id ORDER BY code), priceoverride, price, stock, version"
+
+func scanVariant(scanner interface{ Scan(...any) error }, variant *models.Variant) error {
+	var price sql.NullFloat64
+	err := scanner.Scan(&variant.Id, &variant.ParentId, &variant.Sku, &variant.Size, &variant.Color, &variant.FrameSize, &variant.WheelSize,
+		pq.Array(&variant.Barcodes), &price, &variant.EffectivePrice, &variant.Stock, &variant.Version)
+	if err != nil {
+		return err
+	}
+	if price.Valid {
+		p := float32(price.Float64)
+		variant.Price = &p
+	}
+	return nil
+}
+
+// Methods for performing CRUD on the variants of a product
+
+// CreateVariant adds a variant to the parent product. Variants cannot have variants of their own.
+func CreateVariant(ctx context.Context, db DBTX, variant models.Variant) (int, error) {
+	if err := validation.Validate(variant); err != nil {
+		return -1, err
+	}
+	id, err := auditedCreate(ctx, db, "variant", variantOf(variant.ParentId), func(tx DBTX) (int, error) {
+		var id int
+		var name string
+		var price float32
+		var grandparent sql.NullInt32
+		err := tx.QueryRowContext(ctx, "SELECT name, price, parentid FROM products WHERE id = $1 FOR UPDATE", variant.ParentId).
+			Scan(&name, &price, &grandparent)
+		if err != nil {
+			return id, err
+		}
+		if grandparent.Valid {
+			return id, invalidf("product %d is a variant and cannot have variants", variant.ParentId)
+		}
+		if variant.Price != nil {
+			price = *variant.Price
+		}
+		err = tx.QueryRowContext(ctx, "INSERT INTO products (parentid, name, price, priceoverride, sku, size, color, framesize, wheelsize, stock) "+
+			"VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10) RETURNING id",
+			variant.ParentId, name, price, variant.Price, variant.Sku, variant.Size, variant.Color, variant.FrameSize, variant.WheelSize, variant.Stock).Scan(&id)
+		if err != nil {
+			return id, err
+		}
+		if err := setBarcodes(ctx, tx, id, variant.Barcodes); err != nil {
+			return id, err
+		}
+		if err := adjustStock(ctx, tx, id, variant.Stock); err != nil {
+			return id, err
+		}
+		return id, recordPrice(ctx, tx, id)
+	})
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func GetVariant(ctx context.Context, db DBTX, parentId int, id int) (models.Variant, error) {
+	var variant models.Variant
+	row := db.QueryRowContext(ctx, "SELECT "+variantColumns+" FROM products WHERE id = $1 AND parentid = $2", id, parentId)
+	err := scanVariant(row, &variant)
+	return variant, err
+}
+
+// variantOf gets the variants of the parent product by their id alone
+func variantOf(parentId int) func(context.Context, DBTX, int) (models.Variant, error) {
+	return func(ctx context.Context, db DBTX, id int) (models.Variant, error) {
+		return GetVariant(ctx, db, parentId, id)
+	}
+}
+
+// GetVariants returns the variants of the product, or sql.ErrNoRows when there is no such product
+func GetVariants(ctx context.Context, db DBTX, parentId int) ([]models.Variant, error) {
+	variants := []models.Variant{}
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)", parentId).Scan(&exists)
+	if err != nil {
+		return variants, err
+	}
+	if !exists {
+		return variants, sql.ErrNoRows
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT "+variantColumns+" FROM products WHERE parentid = $1 ORDER BY id", parentId)
+	if err != nil {
+		return variants, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var variant models.Variant
+		if err := scanVariant(rows, &variant); err != nil {
+			return variants, err
+		}
+		variants = append(variants, variant)
+	}
+	return variants, rows.Err()
+}
+
+// UpdateVariant updates the variant if it is still at variant.Version, and returns its new version.
+// Without a price override the variant goes back to the price of its parent. A change of stock
+// is posted to the inventory movements as an adjustment.
+func UpdateVariant(ctx context.Context, db DBTX, variant models.Variant) (int, error) {
+	if err := validation.Validate(variant); err != nil {
+		return -1, err
+	}
+	var version int
+	err := auditedUpdate(ctx, db, "variant", variant.Id, variantOf(variant.ParentId), func(tx DBTX) error {
+		before, err := lockedPrice(ctx, tx, variant.Id)
+		if err != nil {
+			return err
+		}
+		var stock int
+		err = tx.QueryRowContext(ctx, "SELECT stock FROM products WHERE id = $1", variant.Id).Scan(&stock)
+		if err != nil {
+			return err
+		}
+		var price float32
+		err = tx.QueryRowContext(ctx, "UPDATE products SET sku = NULLIF($1, ''), size = $2, color = $3, framesize = $4, wheelsize = $5, stock = $6, "+
+			"priceoverride = $7, price = COALESCE($7, (SELECT parent.price FROM products parent WHERE parent.id = products.parentid)), version = version + 1 "+
+			"WHERE id = $8 AND parentid = $9 AND version = $10 RETURNING version, price",
+			variant.Sku, variant.Size, variant.Color, variant.FrameSize, variant.WheelSize, variant.Stock,
+			variant.Price, variant.Id, variant.ParentId, variant.Version).Scan(&version, &price)
+		if errors.Is(err, sql.ErrNoRows) {
+			return versionConflict(ctx, tx, "products", "id", variant.Id)
+		}
+		if err != nil {
+			return err
+		}
+		if err := setBarcodes(ctx, tx, variant.Id, variant.Barcodes); err != nil {
+			return err
+		}
+		if err := adjustStock(ctx, tx, variant.Id, variant.Stock-stock); err != nil {
+			return err
+		}
+		if price == before {
+			return nil
+		}
+		return recordPrice(ctx, tx, variant.Id)
+	})
+	if err != nil {
+		return -1, err
+	}
+	return version, nil
+}
+
+// DeleteVariant deletes the variant if it is still at the given version
+func DeleteVariant(ctx context.Context, db DBTX, parentId int, id int, version int) error {
+	return auditedDelete(ctx, db, "variant", id, variantOf(parentId), func(tx DBTX) error {
+		return versionedExec(ctx, tx, "products", "id", id,
+			"DELETE FROM products WHERE id = $1 AND parentid = $2 AND version = $3", id, parentId, version)
+	})
+}
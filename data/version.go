@@ -0,0 +1,45 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// FirstVersion is the version of a newly created product, customer, manufacturer or bike.
+// Every update increments it, and updates and deletes only apply to the version the client
+// has seen, so that concurrent edits do not overwrite each other.
+const FirstVersion = 1
+
+var ErrVersionConflict = errors.New("the resource has been changed since it was read")
+
+// versionConflict finds out why an update or delete guarded by a version changed no row:
+// either the row does not exist, or it has another version
+func versionConflict(ctx context.Context, db DBTX, table string, key string, value any) error {
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM "+table+" WHERE "+key+" = $1)", value).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	return ErrVersionConflict
+}
+
+// versionedExec runs a delete or update guarded by a version, and reports a missing row or
+// a version conflict when it changed nothing
+func versionedExec(ctx context.Context, db DBTX, table string, key string, value any, query string, args ...any) error {
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return versionConflict(ctx, db, table, key, value)
+	}
+	return nil
+}
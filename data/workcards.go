@@ -0,0 +1,60 @@
+package data
+
+import (
+	"api/data/models"
+	"context"
+)
+
+// Methods for performing CRUD on the workcards table
+func CreateWorkcard(ctx context.Context, db DBTX, workcard models.Workcard) (int, error) {
+	id, err := auditedCreate(ctx, db, "workcard", GetWorkcard, func(tx DBTX) (int, error) {
+		var id int
+		err := tx.QueryRowContext(ctx, "INSERT INTO workcards (status, framenumber, estimatedminutes) VALUES ($1, $2, COALESCE($3, 0)) RETURNING id;",
+			workcard.Status, workcard.FrameNumber, workcard.EstimatedMinutes).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func GetWorkcard(ctx context.Context, db DBTX, id int) (models.Workcard, error) {
+	var workcard models.Workcard
+	row := db.QueryRowContext(ctx, "SELECT id, status, framenumber, created, estimatedminutes FROM workcards WHERE id = $1", id)
+	if row.Err() != nil {
+		return workcard, row.Err()
+	}
+	err := row.Scan(&workcard.Id, &workcard.Status, &workcard.FrameNumber, &workcard.Created, &workcard.EstimatedMinutes)
+	if err != nil {
+		return workcard, err
+	}
+	return workcard, nil
+}
+
+func GetWorkcards(ctx context.Context, db DBTX) ([]models.Workcard, error) {
+	var workcards []models.Workcard
+	rows, err := db.QueryContext(ctx, "SELECT id, status, framenumber, created, estimatedminutes FROM workcards ORDER BY created")
+	if err != nil {
+		return workcards, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var workcard models.Workcard
+		err := rows.Scan(&workcard.Id, &workcard.Status, &workcard.FrameNumber, &workcard.Created, &workcard.EstimatedMinutes)
+		if err != nil {
+			return workcards, err
+		}
+		workcards = append(workcards, workcard)
+	}
+	return workcards, nil
+}
+
+func UpdateWorkcard(ctx context.Context, db DBTX, workcard models.Workcard) error {
+	return auditedUpdate(ctx, db, "workcard", workcard.Id, GetWorkcard, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, "UPDATE workcards SET status = $1, estimatedminutes = COALESCE($2, estimatedminutes) WHERE id = $3;",
+			workcard.Status, workcard.EstimatedMinutes, workcard.Id)
+		return err
+	})
+}
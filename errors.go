@@ -0,0 +1,140 @@
+package main
+
+import (
+	"api/data"
+	"api/validation"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Machine-readable error codes used in the problem responses
+const (
+	CodeBadRequest       = "bad_request"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeConflict         = "conflict"
+	CodePrecondition     = "precondition_failed"
+	CodeIfMatchRequired  = "if_match_required"
+	CodeUnsupportedMedia = "unsupported_media_type"
+	CodeTooLarge         = "request_too_large"
+	CodeValidationFailed = "validation_failed"
+	CodeInternal         = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details body
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Code     string `json:"code"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// Errors lists the fields that failed validation
+	Errors validation.Errors `json:"errors,omitempty"`
+}
+
+// writeProblem writes a problem+json response with the given status and code
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	writeProblemBody(w, r, Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Code:     code,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	})
+}
+
+func writeProblemBody(w http.ResponseWriter, r *http.Request, problem Problem) {
+	j, err := json.Marshal(problem)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	w.Write(j)
+}
+
+// writeError maps an error to a problem response. Errors that are not recognised are
+// logged and answered with a generic 500, so database internals are not leaked.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var invalid *data.InvalidError
+	var fields validation.Errors
+	var pqErr *pq.Error
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var numErr *strconv.NumError
+	var timeErr *time.ParseError
+	var tooLarge *http.MaxBytesError
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "the requested resource does not exist")
+	case errors.As(err, &fields):
+		writeProblemBody(w, r, Problem{
+			Type:     "about:blank",
+			Title:    http.StatusText(http.StatusUnprocessableEntity),
+			Status:   http.StatusUnprocessableEntity,
+			Code:     CodeValidationFailed,
+			Detail:   "one or more fields are invalid",
+			Instance: r.URL.Path,
+			Errors:   fields,
+		})
+	case errors.As(err, &invalid):
+		writeProblem(w, r, http.StatusUnprocessableEntity, CodeValidationFailed, invalid.Message)
+	case errors.Is(err, data.ErrInvalidCredentials), errors.Is(err, data.ErrInvalidSession), errors.Is(err, data.ErrInvalidApiKey):
+		writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, err.Error())
+	case errors.Is(err, data.ErrOutsideWorkingHours), errors.Is(err, data.ErrSlotUnavailable), errors.Is(err, data.ErrNotBooked),
+		errors.Is(err, data.ErrClockedIn), errors.Is(err, data.ErrNotClockedIn),
+		errors.Is(err, data.ErrIdempotencyKeyReused), errors.Is(err, data.ErrIdempotencyKeyInProgress):
+		writeProblem(w, r, http.StatusConflict, CodeConflict, err.Error())
+	case errors.Is(err, data.ErrVersionConflict):
+		writeProblem(w, r, http.StatusPreconditionFailed, CodePrecondition, err.Error())
+	case errors.Is(err, errIfMatchRequired):
+		writeProblem(w, r, http.StatusPreconditionRequired, CodeIfMatchRequired, err.Error())
+	case errors.Is(err, errUnsupportedPatch):
+		writeProblem(w, r, http.StatusUnsupportedMediaType, CodeUnsupportedMedia, err.Error())
+	case errors.As(err, &tooLarge):
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, CodeTooLarge, fmt.Sprintf("the request body is larger than %d bytes", tooLarge.Limit))
+	case errors.As(err, &pqErr):
+		writePqError(w, r, pqErr)
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "the request body is not valid JSON: "+err.Error())
+	case errors.As(err, &numErr), errors.As(err, &timeErr):
+		writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, err.Error())
+	default:
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "an unexpected error occurred")
+	}
+}
+
+// writePqError maps Postgres constraint violations to 409 and 422 responses
+func writePqError(w http.ResponseWriter, r *http.Request, err *pq.Error) {
+	switch err.Code.Name() {
+	case "unique_violation":
+		writeProblem(w, r, http.StatusConflict, CodeConflict, "a record with the same value already exists")
+	case "foreign_key_violation":
+		writeProblem(w, r, http.StatusConflict, CodeConflict, "the record refers to, or is referred to by, another record")
+	case "not_null_violation":
+		writeProblem(w, r, http.StatusUnprocessableEntity, CodeValidationFailed, err.Column+" is required")
+	case "check_violation":
+		writeProblem(w, r, http.StatusUnprocessableEntity, CodeValidationFailed, "a value is out of the allowed range")
+	case "invalid_text_representation", "invalid_datetime_format", "string_data_right_truncation", "numeric_value_out_of_range":
+		writeProblem(w, r, http.StatusUnprocessableEntity, CodeValidationFailed, "a value has the wrong format or length")
+	default:
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "an unexpected error occurred")
+	}
+}
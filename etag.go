@@ -0,0 +1,63 @@
+package main
+
+import (
+	"api/data"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errIfMatchRequired = errors.New("the If-Match header is required; send the ETag of the resource you read")
+
+// etag is the entity tag of a resource at the given version
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// writeTagged writes v like writeJSON with the ETag of its version. A GET whose
+// If-None-Match has the same tag is answered with 304 Not Modified and no body.
+func writeTagged(w http.ResponseWriter, r *http.Request, status int, version int, v any) {
+	tag := etag(version)
+	w.Header().Set("ETag", tag)
+	if r.Method == http.MethodGet && matchesTag(r.Header.Get("If-None-Match"), tag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, r, status, v)
+}
+
+// ifMatch returns the version a PUT or DELETE applies to, which is the current version
+// when the If-Match header has its ETag or is "*". Any other tag means the client has
+// read an older version, and data.ErrVersionConflict is returned.
+func ifMatch(r *http.Request, current int) (int, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return -1, errIfMatchRequired
+	}
+	if !matchesTag(header, etag(current), false) {
+		return -1, data.ErrVersionConflict
+	}
+	return current, nil
+}
+
+// ifMatchOptional is ifMatch for requests that did not require If-Match before, and
+// only checks the header when the client sends it
+func ifMatchOptional(r *http.Request, current int) (int, error) {
+	if r.Header.Get("If-Match") == "" {
+		return current, nil
+	}
+	return ifMatch(r, current)
+}
+
+// matchesTag reports whether a comma separated If-Match or If-None-Match header contains tag or "*".
+// Weak tags only match when weak is set, since If-Match uses the strong comparison.
+func matchesTag(header string, tag string, weak bool) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || t == tag || (weak && t == "W/"+tag) {
+			return true
+		}
+	}
+	return false
+}
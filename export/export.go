@@ -0,0 +1,144 @@
+package export
+
+import (
+	"api/data"
+	"api/data/models"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats that can be exported. JSON Lines and NDJSON are the same format under two names:
+// one JSON object per line.
+const (
+	CSV    = "csv"
+	JSONL  = "jsonl"
+	NDJSON = "ndjson"
+)
+
+// Formats that can be exported
+var Formats = []string{CSV, JSONL, NDJSON}
+
+// Entities that can be exported
+var Entities = []string{"products", "customers", "manufacturers", "bikes"}
+
+// ContentType is the media type of a format
+func ContentType(format string) string {
+	if format == CSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// Export writes every row of the entity to w in the format. Rows are written as they are
+// read from the database, so memory use does not grow with the size of the table.
+func Export(ctx context.Context, db data.DBTX, entity string, format string, w io.Writer) error {
+	out, err := newWriter(w, format)
+	if err != nil {
+		return err
+	}
+	switch entity {
+	case "products":
+		out.header("id", "parentId", "name", "price", "priceOverride", "size", "color", "sku", "barcodes", "stock", "version")
+		err = data.ExportProducts(ctx, db, func(p models.ExportedProduct) error {
+			var parentId, priceOverride string
+			if p.ParentId != nil {
+				parentId = strconv.Itoa(*p.ParentId)
+			}
+			if p.PriceOverride != nil {
+				priceOverride = price(*p.PriceOverride)
+			}
+			return out.write(p, strconv.Itoa(p.Id), parentId, p.Name, price(p.Price), priceOverride, p.Size, p.Color, p.Sku,
+				strings.Join(p.Barcodes, " "), strconv.Itoa(p.Stock), strconv.Itoa(p.Version))
+		})
+	case "customers":
+		out.header("id", "firstName", "lastName", "phone", "email", "street", "city", "country", "version")
+		err = data.ExportCustomers(ctx, db, func(c models.Customer) error {
+			return out.write(c, strconv.Itoa(c.Id), c.FirstName, c.LastName, c.Phone, c.Email,
+				c.Address.Street, c.Address.City, c.Address.Country, strconv.Itoa(c.Version))
+		})
+	case "manufacturers":
+		out.header("id", "name", "phone", "version")
+		err = data.ExportManufacturers(ctx, db, func(m models.Manufacturer) error {
+			return out.write(m, strconv.Itoa(m.Id), m.Name, m.Phone, strconv.Itoa(m.Version))
+		})
+	case "bikes":
+		out.header("frameNumber", "productId", "productName", "soldAt", "ownerId", "ownerFirstName", "ownerLastName", "ownerPhone", "ownerEmail", "version")
+		err = data.ExportBikes(ctx, db, func(b models.Bike) error {
+			var soldAt, owner string
+			if b.SoldAt != nil {
+				soldAt = b.SoldAt.Format(time.RFC3339)
+			}
+			if b.Owner.Id != 0 {
+				owner = strconv.Itoa(b.Owner.Id)
+			}
+			return out.write(b, b.FrameNumber, strconv.Itoa(b.Id), b.Name, soldAt, owner,
+				b.Owner.FirstName, b.Owner.LastName, b.Owner.Phone, b.Owner.Email, strconv.Itoa(b.BikeVersion))
+		})
+	default:
+		return fmt.Errorf("unknown entity %q, expected one of %v", entity, Entities)
+	}
+	if err != nil {
+		return err
+	}
+	return out.flush()
+}
+
+// writer writes rows either as CSV records or as JSON objects, one per line
+type writer struct {
+	csv  *csv.Writer
+	buf  *bufio.Writer
+	json *json.Encoder
+	err  error
+}
+
+func newWriter(w io.Writer, format string) (*writer, error) {
+	switch format {
+	case CSV:
+		return &writer{csv: csv.NewWriter(w)}, nil
+	case JSONL, NDJSON:
+		buf := bufio.NewWriter(w)
+		return &writer{buf: buf, json: json.NewEncoder(buf)}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected %s, %s or %s", format, CSV, JSONL, NDJSON)
+	}
+}
+
+// header writes the header row of a CSV export
+func (w *writer) header(columns ...string) {
+	if w.csv != nil {
+		w.err = w.csv.Write(columns)
+	}
+}
+
+// write writes v as JSON, or its values as a CSV record
+func (w *writer) write(v any, values ...string) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.csv != nil {
+		return w.csv.Write(values)
+	}
+	return w.json.Encode(v)
+}
+
+func (w *writer) flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.csv != nil {
+		w.csv.Flush()
+		return w.csv.Error()
+	}
+	return w.buf.Flush()
+}
+
+func price(p float32) string {
+	return strconv.FormatFloat(float64(p), 'f', 2, 32)
+}
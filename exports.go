@@ -0,0 +1,94 @@
+package main
+
+import (
+	"api/export"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+// exportHandler streams every row of the entity as CSV, JSON Lines or NDJSON, chosen with ?format=
+// and CSV by default. An error before anything is written is answered with a problem response.
+// Once streaming has started the status cannot be changed any more, so the connection is
+// aborted rather than leaving the client with a file that looks complete.
+func exportHandler(entity string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = export.CSV
+		}
+		if !slices.Contains(export.Formats, format) {
+			writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("format must be one of %v", export.Formats))
+			return
+		}
+
+		w.Header().Set("Content-Type", export.ContentType(format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.%s"`, entity, time.Now().Format(time.DateOnly), format))
+		out := &startedWriter{w: w}
+		if err := export.Export(r.Context(), db, entity, format, out); err != nil {
+			if !out.started {
+				w.Header().Del("Content-Disposition")
+				writeError(w, r, err)
+				return
+			}
+			log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// startedWriter records whether anything has been written to w
+type startedWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (s *startedWriter) Write(p []byte) (int, error) {
+	s.started = true
+	return s.w.Write(p)
+}
+
+// runExportCommand is the export command line, which writes an entity to a file or stdout:
+//
+//	api export [-format csv|jsonl|ndjson] [-o file] products|customers|manufacturers|bikes
+func runExportCommand(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", export.CSV, fmt.Sprintf("output format, one of %v", export.Formats))
+	output := flags.String("o", "", "file to write to instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s export [flags] %v\n", os.Args[0], export.Entities)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || !slices.Contains(export.Entities, flags.Arg(0)) {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	db = initDB()
+	if *output == "" {
+		if err := export.Export(context.Background(), db, flags.Arg(0), *format, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// the file is closed before exiting, since log.Fatal does not run deferred calls
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = export.Export(context.Background(), db, flags.Arg(0), *format, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -2,4 +2,19 @@ module api
 
 go 1.22.4
 
-require github.com/lib/pq v1.10.9 // indirect
+require (
+	github.com/boombuler/barcode v1.1.0
+	github.com/lib/pq v1.10.9
+	github.com/xuri/excelize/v2 v2.8.1
+	golang.org/x/crypto v0.23.0
+)
+
+require (
+	github.com/mohae/deepcopy v0.0.0-20170929034955-c48cc78d4826 // indirect
+	github.com/richardlehane/mscfb v1.0.4 // indirect
+	github.com/richardlehane/msoleps v1.0.3 // indirect
+	github.com/xuri/efp v0.0.0-20231025114914-d1ff6096ae53 // indirect
+	github.com/xuri/nfp v0.0.0-20230919160717-d98342af3f05 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/text v0.15.0 // indirect
+)
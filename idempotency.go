@@ -0,0 +1,150 @@
+package main
+
+import (
+	"api/data"
+	"api/data/models"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// replayedHeaders are the response headers that are stored and replayed with the body
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// IdempotencyWrapper is a middleware handler that makes POST requests with an Idempotency-Key
+// header safe to retry. The first response for a key is stored and replayed for retries within
+// the retention window, and reusing a key for a different request is a conflict. Keys are
+// separate for each user and API key, so it has to be wrapped by the AuthWrapper.
+type IdempotencyWrapper struct {
+	handler http.Handler
+}
+
+// ServeHTTP replays the stored response of a retried request or records the response of a new one
+func (i *IdempotencyWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Idempotency-Key")
+	owner, ok := idempotencyOwner(r)
+	if r.Method != http.MethodPost || key == "" || !ok {
+		i.handler.ServeHTTP(w, r)
+		return
+	}
+
+	// the body is hashed before the handler runs, so it is read into memory up to the
+	// size of the largest request a handler accepts
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	hash.Write(body)
+
+	stored, err := data.StartIdempotentRequest(r.Context(), db, owner, key, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if stored != nil {
+		for name, value := range stored.Header {
+			w.Header().Set(name, value)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.Status)
+		w.Write(stored.Body)
+		return
+	}
+
+	// the outcome is stored even if the client has gone away, so the retry finds it
+	ctx := context.WithoutCancel(r.Context())
+	recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+	finished := false
+	defer func() {
+		// the key is released when the handler fails or panics, so the request can be retried
+		if !finished {
+			if err := data.AbortIdempotentRequest(ctx, db, owner, key); err != nil {
+				log.Printf("releasing idempotency key %s: %v", key, err)
+			}
+		}
+	}()
+	i.handler.ServeHTTP(recorder, r)
+	if recorder.status >= http.StatusInternalServerError {
+		return
+	}
+
+	response := models.IdempotentResponse{Status: recorder.status, Header: map[string]string{}, Body: recorder.body.Bytes()}
+	for _, name := range replayedHeaders {
+		if value := w.Header().Get(name); value != "" {
+			response.Header[name] = value
+		}
+	}
+	if err := data.FinishIdempotentRequest(ctx, db, owner, key, response); err != nil {
+		log.Printf("storing response for idempotency key %s: %v", key, err)
+		return
+	}
+	finished = true
+}
+
+// NewIdempotencyWrapper constructs a new IdempotencyWrapper middleware handler
+func NewIdempotencyWrapper(handlerToWrap http.Handler) *IdempotencyWrapper {
+	return &IdempotencyWrapper{handlerToWrap}
+}
+
+// startIdempotencyCleanup forgets the keys older than retention in the background, every hour,
+// until stop is closed
+func startIdempotencyCleanup(db *sql.DB, retention time.Duration, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			if _, err := data.DeleteExpiredIdempotencyKeys(ctx, db, retention); err != nil {
+				log.Println("idempotency:", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// idempotencyOwner identifies the user or API key the idempotency keys of a request belong to
+func idempotencyOwner(r *http.Request) (string, bool) {
+	if apiKey, ok := currentApiKey(r); ok {
+		return "apikey:" + strconv.Itoa(apiKey.Id), true
+	}
+	if user, ok := currentUser(r); ok {
+		return "user:" + strconv.Itoa(user.Id), true
+	}
+	return "", false
+}
+
+// responseRecorder passes a response on while keeping a copy of its status and body
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"api/data"
+	"api/data/models"
+	"api/spreadsheet"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxImportSize is the largest price list that can be uploaded
+const maxImportSize = 10 << 20
+
+// importColumns are the product fields that can be imported, with the headers they are read
+// from when no mapping is given. Headers are compared without regard to case.
+var importColumns = map[string]string{
+	"name":          "name",
+	"price":         "price",
+	"size":          "size",
+	"color":         "color",
+	"sku":           "sku",
+	"manufacturers": "manufacturers",
+}
+
+// importProductsHandler imports products from a CSV or XLSX price list uploaded as multipart/form-data.
+// The form fields are:
+//   - file: the price list, with a header row
+//   - mapping: optional JSON mapping fields to headers, e.g. {"name": "Description", "price": "Retail"}
+//   - match: the field existing products are matched on, "name" by default or "sku"
+//   - dryRun: "true" to only report what the import would do
+//
+// Several manufacturers are separated by "|". The whole file is imported in one transaction,
+// and nothing is saved when a row has errors.
+func importProductsHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, CodeTooLarge, fmt.Sprintf("the price list is larger than %d MB", maxImportSize>>20))
+			return
+		}
+		writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "the price list must be uploaded as multipart/form-data: "+err.Error())
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "the price list must be uploaded in the file field")
+		return
+	}
+	defer file.Close()
+
+	table, err := spreadsheet.Read(file)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "the price list could not be read: "+err.Error())
+		return
+	}
+	mapping := map[string]string{}
+	if m := r.FormValue("mapping"); m != "" {
+		if err := json.Unmarshal([]byte(m), &mapping); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	opts := models.ImportOptions{Match: r.FormValue("match"), DryRun: r.FormValue("dryRun") == "true"}
+	if opts.Match == "" {
+		opts.Match = "name"
+	}
+	if opts.Match != "name" && opts.Match != "sku" {
+		writeError(w, r, invalidImport("products can only be matched on name or sku, not %q", opts.Match))
+		return
+	}
+	rows, err := productImportRows(table, mapping, opts.Match)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	opts.AllowPriceChanges, err = can(r, data.PermProductsPrice)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	result, err := data.ImportProducts(r.Context(), db, rows, opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if result.Failed > 0 {
+		writeJSON(w, r, http.StatusUnprocessableEntity, result)
+		return
+	}
+	if result.Committed {
+		searchIndex.Invalidate()
+	}
+	writeJSON(w, r, http.StatusOK, result)
+}
+
+// productImportRows reads the products from the rows of a price list, which needs a column for the
+// match field. mapping overrides the headers of importColumns. Cells that cannot be read are
+// reported as errors of their row.
+func productImportRows(table [][]string, mapping map[string]string, match string) ([]models.ProductImportRow, error) {
+	headers := map[string]string{}
+	for field, header := range importColumns {
+		headers[field] = header
+	}
+	for field, header := range mapping {
+		if _, ok := importColumns[field]; !ok {
+			return nil, invalidImport("unknown field in mapping: %s", field)
+		}
+		headers[field] = header
+	}
+	if len(table) == 0 {
+		return nil, invalidImport("the price list is empty")
+	}
+
+	columns := map[string]int{}
+	for i, cell := range table[0] {
+		for field, header := range headers {
+			if strings.EqualFold(strings.TrimSpace(cell), header) {
+				columns[field] = i
+			}
+		}
+	}
+	if _, ok := columns[match]; !ok {
+		return nil, invalidImport("the price list has no %q column", headers[match])
+	}
+
+	var rows []models.ProductImportRow
+	for i, record := range table[1:] {
+		cell := func(field string) (string, bool) {
+			column, ok := columns[field]
+			if !ok || column >= len(record) {
+				return "", false
+			}
+			return strings.TrimSpace(record[column]), true
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		// line numbers count the header, as they do in a spreadsheet
+		row := models.ProductImportRow{Line: i + 2}
+		row.Name, _ = cell("name")
+		if v, ok := cell("price"); ok && v != "" {
+			price, err := parsePrice(v)
+			if err != nil {
+				row.Errors = append(row.Errors, fmt.Sprintf("price is not a number: %s", v))
+			}
+			row.Price = &price
+		}
+		if v, ok := cell("size"); ok {
+			row.Size = &v
+		}
+		if v, ok := cell("color"); ok {
+			row.Color = &v
+		}
+		if v, ok := cell("sku"); ok {
+			row.Sku = &v
+		}
+		if v, ok := cell("manufacturers"); ok {
+			for _, name := range strings.Split(v, "|") {
+				if name = strings.TrimSpace(name); name != "" {
+					row.Manufacturers = append(row.Manufacturers, name)
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parsePrice reads prices like "1299", "1 299,50" and "1,299.50"
+func parsePrice(v string) (float32, error) {
+	v = strings.NewReplacer(" ", "", "\u00a0", "").Replace(v)
+	if strings.Contains(v, ",") && !strings.Contains(v, ".") {
+		v = strings.Replace(v, ",", ".", 1)
+	}
+	v = strings.ReplaceAll(v, ",", "")
+	price, err := strconv.ParseFloat(v, 32)
+	return float32(price), err
+}
+
+func invalidImport(format string, args ...any) error {
+	return &data.InvalidError{Message: fmt.Sprintf(format, args...)}
+}
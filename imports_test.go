@@ -0,0 +1,115 @@
+package main
+
+import (
+	"api/data"
+	"api/data/models"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParsePrice(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    float32
+		wantErr bool
+	}{
+		{"1299", 1299, false},
+		{"1299.50", 1299.5, false},
+		{"1299,50", 1299.5, false},
+		{"1 299,50", 1299.5, false},
+		{"1 299,50", 1299.5, false},
+		{"1,299.50", 1299.5, false},
+		{"1,299,000.00", 1299000, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"kr 1299", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parsePrice(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePrice(%q) error = %v, want error %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parsePrice(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestProductImportRows(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   [][]string
+		mapping map[string]string
+		match   string
+		want    []models.ProductImportRow
+		wantErr bool
+	}{
+		{
+			name: "default headers in any case and order",
+			table: [][]string{
+				{"Price", " NAME ", "Manufacturers", "Color"},
+				{"499", "Helmet", "Bell | Giro", "red"},
+			},
+			match: "name",
+			want: []models.ProductImportRow{
+				{Line: 2, Name: "Helmet", Price: ptr(float32(499)), Color: ptr("red"), Manufacturers: []string{"Bell", "Giro"}},
+			},
+		},
+		{
+			name: "mapping, blank rows and a bad price",
+			table: [][]string{
+				{"Description", "Retail", "Size"},
+				{"Helmet", "1 299,50", "M"},
+				{"", "", ""},
+				{"Lock", "cheap", ""},
+			},
+			mapping: map[string]string{"name": "Description", "price": "Retail"},
+			match:   "name",
+			want: []models.ProductImportRow{
+				{Line: 2, Name: "Helmet", Price: ptr(float32(1299.5)), Size: ptr("M")},
+				{Line: 4, Name: "Lock", Price: ptr(float32(0)), Size: ptr(""), Errors: []string{"price is not a number: cheap"}},
+			},
+		},
+		{
+			name: "matching on sku without a name column",
+			table: [][]string{
+				{"sku", "price"},
+				{"HEL-M", "499"},
+				{"LOCK-1"},
+			},
+			match: "sku",
+			want: []models.ProductImportRow{
+				{Line: 2, Sku: ptr("HEL-M"), Price: ptr(float32(499))},
+				{Line: 3, Sku: ptr("LOCK-1")},
+			},
+		},
+		{name: "no column to match on", table: [][]string{{"price"}, {"499"}}, match: "name", wantErr: true},
+		{name: "no sku column", table: [][]string{{"name"}, {"Helmet"}}, match: "sku", wantErr: true},
+		{name: "unknown field in mapping", table: [][]string{{"name"}}, mapping: map[string]string{"weight": "Weight"}, match: "name", wantErr: true},
+		{name: "empty", table: nil, match: "name", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := productImportRows(tt.table, tt.mapping, tt.match)
+			if tt.wantErr {
+				var invalid *data.InvalidError
+				if !errors.As(err, &invalid) {
+					t.Fatalf("productImportRows() error = %v, want an InvalidError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("productImportRows() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
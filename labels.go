@@ -0,0 +1,92 @@
+package main
+
+import (
+	"api/data"
+	"api/labels"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// Functions for printing labels. The format is chosen with ?format=, and is PNG for
+// barcodes and SVG for labels by default.
+
+// getProductBarcodeHandler renders the barcode of a product or variant as PNG or SVG
+func getProductBarcodeHandler(w http.ResponseWriter, r *http.Request) {
+	label, ok := productLabel(w, r)
+	if !ok {
+		return
+	}
+	writeLabel(w, r, labels.BarcodeFormats, labels.PNG, func(format string) ([]byte, error) {
+		return labels.RenderBarcode(label, format)
+	})
+}
+
+// getProductLabelHandler renders the shelf label of a product or variant as SVG or ZPL
+func getProductLabelHandler(w http.ResponseWriter, r *http.Request) {
+	label, ok := productLabel(w, r)
+	if !ok {
+		return
+	}
+	writeLabel(w, r, labels.LabelFormats, labels.SVG, func(format string) ([]byte, error) {
+		return labels.Render(label, format)
+	})
+}
+
+// getBikeLabelHandler renders the sticker of a bike as SVG or ZPL
+func getBikeLabelHandler(w http.ResponseWriter, r *http.Request) {
+	bike, err := data.GetBike(r.Context(), db, r.PathValue("framenumber"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// GetBike only has the id of the product, and the sticker shows its model and size
+	bike.Product, err = data.GetProduct(r.Context(), db, bike.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeLabel(w, r, labels.LabelFormats, labels.SVG, func(format string) ([]byte, error) {
+		return labels.Render(labels.BikeLabel(bike), format)
+	})
+}
+
+func productLabel(w http.ResponseWriter, r *http.Request) (labels.Label, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return labels.Label{}, false
+	}
+	product, err := data.GetProduct(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return labels.Label{}, false
+	}
+	label, err := labels.ProductLabel(product)
+	if err != nil {
+		writeError(w, r, err)
+		return labels.Label{}, false
+	}
+	return label, true
+}
+
+// writeLabel renders a label in the requested format, one of formats, and writes it
+func writeLabel(w http.ResponseWriter, r *http.Request, formats []string, fallback string, render func(format string) ([]byte, error)) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = fallback
+	}
+	if !slices.Contains(formats, format) {
+		writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("format must be one of %v", formats))
+		return
+	}
+	b, err := render(format)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", labels.ContentType(format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
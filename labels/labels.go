@@ -0,0 +1,108 @@
+package labels
+
+import (
+	"api/data"
+	"api/data/models"
+	"fmt"
+	"image/color"
+	"strconv"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/ean"
+)
+
+// Symbologies barcodes are printed in
+const (
+	Code128 = "code128"
+	EAN13   = "ean13"
+)
+
+// Templates of the labels
+const (
+	// Shelf is a price label for a shelf, with the name, size, price and barcode of a product
+	Shelf = "shelf"
+	// Bike is a sticker for a bike, with its frame number as a barcode and its model and size
+	Bike = "bike"
+)
+
+// Label is what is printed on a label. Code is printed as a barcode in Symbology,
+// with the code itself below it.
+type Label struct {
+	Template  string
+	Title     string
+	Size      string
+	Price     string
+	Code      string
+	Symbology string
+}
+
+// ProductLabel is the shelf label of a product or variant. Its barcode is the first
+// of its barcodes, printed as EAN-13 when it is an EAN-13 or UPC-A, or else its sku.
+func ProductLabel(product models.Product) (Label, error) {
+	label := Label{Template: Shelf, Title: product.Name, Size: product.Size, Price: price(product.Price)}
+	switch {
+	case len(product.Barcodes) > 0:
+		label.Code, label.Symbology = product.Barcodes[0], Code128
+		// a UPC-A is an EAN-13 starting with 0, and is printed with the same bars
+		if n := len(label.Code); n == 12 || n == 13 {
+			label.Code, label.Symbology = fmt.Sprintf("%013s", label.Code), EAN13
+		}
+	case product.Sku != "":
+		label.Code, label.Symbology = product.Sku, Code128
+	default:
+		return label, &data.InvalidError{Message: fmt.Sprintf("product %d has no barcode or sku to print", product.Id)}
+	}
+	return label, nil
+}
+
+// BikeLabel is the sticker of a bike
+func BikeLabel(bike models.Bike) Label {
+	return Label{Template: Bike, Title: bike.Name, Size: bike.Size, Code: bike.FrameNumber, Symbology: Code128}
+}
+
+// bar is a run of black modules of a barcode, X and Width counted in modules
+type bar struct {
+	X     int
+	Width int
+}
+
+// encode returns the bars of the barcode of the label and its width in modules
+func (l Label) encode() ([]bar, int, error) {
+	var bc barcode.Barcode
+	var err error
+	switch l.Symbology {
+	case EAN13:
+		bc, err = ean.Encode(l.Code)
+	case Code128:
+		bc, err = code128.Encode(l.Code)
+	default:
+		return nil, 0, fmt.Errorf("unknown symbology %q", l.Symbology)
+	}
+	if err != nil {
+		return nil, 0, &data.InvalidError{Message: fmt.Sprintf("%s cannot be printed as %s: %v", l.Code, l.Symbology, err)}
+	}
+
+	var bars []bar
+	width := bc.Bounds().Dx()
+	for x := 0; x < width; x++ {
+		if !isBlack(bc.At(bc.Bounds().Min.X+x, bc.Bounds().Min.Y)) {
+			continue
+		}
+		if n := len(bars); n > 0 && bars[n-1].X+bars[n-1].Width == x {
+			bars[n-1].Width++
+		} else {
+			bars = append(bars, bar{X: x, Width: 1})
+		}
+	}
+	return bars, width, nil
+}
+
+func isBlack(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r+g+b < 3*0x8000
+}
+
+func price(p float32) string {
+	return strconv.FormatFloat(float64(p), 'f', 2, 32)
+}
@@ -0,0 +1,117 @@
+package labels
+
+import (
+	"api/data"
+	"api/data/models"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestProductLabel(t *testing.T) {
+	tests := []struct {
+		name          string
+		product       models.Product
+		wantCode      string
+		wantSymbology string
+		wantErr       bool
+	}{
+		{
+			name:          "EAN-13",
+			product:       models.Product{Barcodes: []string{"4006381333931"}},
+			wantCode:      "4006381333931",
+			wantSymbology: EAN13,
+		},
+		{
+			name:          "UPC-A is padded to EAN-13",
+			product:       models.Product{Barcodes: []string{"036000291452"}},
+			wantCode:      "0036000291452",
+			wantSymbology: EAN13,
+		},
+		{
+			name:          "only the first barcode is printed",
+			product:       models.Product{Barcodes: []string{"036000291452", "4006381333931"}},
+			wantCode:      "0036000291452",
+			wantSymbology: EAN13,
+		},
+		{
+			name:          "EAN-8 is printed as Code 128",
+			product:       models.Product{Barcodes: []string{"96385074"}},
+			wantCode:      "96385074",
+			wantSymbology: Code128,
+		},
+		{
+			name:          "GTIN-14 is printed as Code 128",
+			product:       models.Product{Barcodes: []string{"10012345678902"}},
+			wantCode:      "10012345678902",
+			wantSymbology: Code128,
+		},
+		{
+			name:          "sku without barcodes",
+			product:       models.Product{Sku: "HEL-M-RED"},
+			wantCode:      "HEL-M-RED",
+			wantSymbology: Code128,
+		},
+		{
+			name:    "nothing to print",
+			product: models.Product{Id: 7},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.product.Name, tt.product.Size, tt.product.Price = "City Helmet", "M", 499.5
+			label, err := ProductLabel(tt.product)
+			if tt.wantErr {
+				var invalid *data.InvalidError
+				if !errors.As(err, &invalid) {
+					t.Fatalf("ProductLabel() error = %v, want an InvalidError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if label.Code != tt.wantCode || label.Symbology != tt.wantSymbology {
+				t.Errorf("ProductLabel() = %s %s, want %s %s", label.Symbology, label.Code, tt.wantSymbology, tt.wantCode)
+			}
+			if label.Template != Shelf || label.Title != "City Helmet" || label.Size != "M" || label.Price != "499.50" {
+				t.Errorf("ProductLabel() = %+v", label)
+			}
+		})
+	}
+}
+
+func TestEncodeEAN13(t *testing.T) {
+	label, err := ProductLabel(models.Product{Barcodes: []string{"036000291452"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bars, modules, err := label.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an EAN-13 is 95 modules wide and starts with the 101 guard pattern
+	if modules != 95 {
+		t.Errorf("modules = %d, want 95", modules)
+	}
+	if len(bars) < 2 || bars[0] != (bar{X: 0, Width: 1}) || bars[1] != (bar{X: 2, Width: 1}) {
+		t.Errorf("bars start with %v, want the start guard", bars[:2])
+	}
+}
+
+func TestRenderBikeLabel(t *testing.T) {
+	bike := models.Bike{Product: models.Product{Name: "Cargo E", Size: "L"}, FrameNumber: "WTU123-4567"}
+	label := BikeLabel(bike)
+	for _, format := range LabelFormats {
+		out, err := Render(label, format)
+		if err != nil {
+			t.Fatalf("Render(%s) = %v", format, err)
+		}
+		for _, text := range []string{"Cargo E", "WTU123-4567"} {
+			if !strings.Contains(string(out), text) {
+				t.Errorf("Render(%s) does not contain %q", format, text)
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package labels
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"text/template"
+)
+
+// Formats labels and barcodes are rendered in. PNG only holds the bars of a barcode,
+// since text would need fonts, so labels are rendered as SVG or as ZPL for Zebra printers.
+const (
+	PNG = "png"
+	SVG = "svg"
+	ZPL = "zpl"
+)
+
+// BarcodeFormats are the formats a barcode can be rendered in
+var BarcodeFormats = []string{PNG, SVG}
+
+// LabelFormats are the formats a label can be rendered in
+var LabelFormats = []string{SVG, ZPL}
+
+// ContentType is the media type of a format
+func ContentType(format string) string {
+	switch format {
+	case PNG:
+		return "image/png"
+	case SVG:
+		return "image/svg+xml"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// Sizes of the rendered barcodes in pixels. A quiet zone of white modules on both sides
+// of the bars is needed for scanners to find the start and end of the barcode.
+const (
+	moduleWidth   = 2
+	quietZone     = 10 * moduleWidth
+	barHeight     = 80
+	minLabelWidth = 360
+	labelTop      = 80
+)
+
+// RenderBarcode renders only the barcode of the label, as PNG or SVG
+func RenderBarcode(label Label, format string) ([]byte, error) {
+	bars, modules, err := label.encode()
+	if err != nil {
+		return nil, err
+	}
+	width := modules*moduleWidth + 2*quietZone
+	switch format {
+	case PNG:
+		img := image.NewGray(image.Rect(0, 0, width, barHeight+2*quietZone))
+		for i := range img.Pix {
+			img.Pix[i] = 0xff
+		}
+		for _, b := range bars {
+			for x := quietZone + b.X*moduleWidth; x < quietZone+(b.X+b.Width)*moduleWidth; x++ {
+				for y := quietZone; y < quietZone+barHeight; y++ {
+					img.SetGray(x, y, color.Gray{})
+				}
+			}
+		}
+		var buf bytes.Buffer
+		err := png.Encode(&buf, img)
+		return buf.Bytes(), err
+	case SVG:
+		return execute("barcode.svg", newSVG(label, bars, modules, width, 0))
+	default:
+		return nil, fmt.Errorf("unknown barcode format %q", format)
+	}
+}
+
+// Render renders the label with its template, as SVG or ZPL
+func Render(label Label, format string) ([]byte, error) {
+	bars, modules, err := label.encode()
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case SVG:
+		width := max(modules*moduleWidth+2*quietZone, minLabelWidth)
+		return execute(label.Template+".svg", newSVG(label, bars, modules, width, labelTop))
+	case ZPL:
+		return execute(label.Template+".zpl", label)
+	default:
+		return nil, fmt.Errorf("unknown label format %q", format)
+	}
+}
+
+func execute(name string, data any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// svgLabel is a label laid out in pixels, with the bars centered below the text at Top
+type svgLabel struct {
+	Label
+	Width     int
+	Height    int
+	Top       int
+	BarHeight int
+	Bars      []bar
+	Bottom    int
+	Margin    int
+}
+
+func newSVG(label Label, bars []bar, modules int, width int, top int) svgLabel {
+	left := (width - modules*moduleWidth) / 2
+	scaled := make([]bar, len(bars))
+	for i, b := range bars {
+		scaled[i] = bar{X: left + b.X*moduleWidth, Width: b.Width * moduleWidth}
+	}
+	return svgLabel{
+		Label:     label,
+		Width:     width,
+		Height:    top + barHeight + 2*quietZone + 20,
+		Top:       top + quietZone,
+		BarHeight: barHeight,
+		Bars:      scaled,
+		Bottom:    top + quietZone + barHeight + 20,
+		Margin:    quietZone,
+	}
+}
+
+// zplEscaper escapes the characters that are commands in ZPL as hexadecimal, which fields
+// read with ^FH\ turn back into the characters
+var zplEscaper = strings.NewReplacer(`\`, `\5C`, `^`, `\5E`, `~`, `\7E`)
+
+var templates = template.Must(template.New("labels").Funcs(template.FuncMap{
+	"xml": template.HTMLEscapeString,
+	"zpl": zplEscaper.Replace,
+	"div": func(a, b int) int { return a / b },
+	"sub": func(a, b int) int { return a - b },
+}).Parse(`
+{{- define "bars" -}}
+<g fill="black">{{range .Bars}}<rect x="{{.X}}" y="{{$.Top}}" width="{{.Width}}" height="{{$.BarHeight}}"/>{{end}}</g>
+<text x="{{div .Width 2}}" y="{{.Bottom}}" text-anchor="middle" font-size="16" font-family="monospace">{{xml .Code}}</text>
+{{- end}}
+
+{{- define "barcode.svg" -}}
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+<rect width="100%" height="100%" fill="white"/>
+{{template "bars" .}}
+</svg>
+{{end}}
+
+{{- define "shelf.svg" -}}
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}" font-family="sans-serif">
+<rect width="100%" height="100%" fill="white"/>
+<text x="{{.Margin}}" y="28" font-size="20" font-weight="bold">{{xml .Title}}</text>
+{{- if .Size}}
+<text x="{{.Margin}}" y="64" font-size="16">{{xml .Size}}</text>
+{{- end}}
+<text x="{{sub .Width .Margin}}" y="66" text-anchor="end" font-size="28" font-weight="bold">{{xml .Price}}</text>
+{{template "bars" .}}
+</svg>
+{{end}}
+
+{{- define "bike.svg" -}}
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}" font-family="sans-serif">
+<rect width="100%" height="100%" fill="white"/>
+<text x="{{.Margin}}" y="28" font-size="20" font-weight="bold">{{xml .Title}}</text>
+{{- if .Size}}
+<text x="{{.Margin}}" y="60" font-size="16">Size {{xml .Size}}</text>
+{{- end}}
+{{template "bars" .}}
+</svg>
+{{end}}
+
+{{- define "zplBarcode" -}}
+{{if eq .Symbology "ean13"}}^BEN,80,Y,N^FD{{slice .Code 0 12}}^FS{{else}}^BCN,80,Y,N,N,A^FH\^FD{{zpl .Code}}^FS{{end}}
+{{- end}}
+
+{{- define "shelf.zpl" -}}
+^XA
+^CI28
+^PW457
+^LL254
+^FO20,15^A0N,28,28^FB417,2,0,L^FH\^FD{{zpl .Title}}^FS
+{{- if .Size}}
+^FO20,85^A0N,24,24^FH\^FD{{zpl .Size}}^FS
+{{- end}}
+^FO20,75^A0N,40,40^FB417,1,0,R^FH\^FD{{zpl .Price}}^FS
+^FO20,135^BY2{{template "zplBarcode" .}}
+^XZ
+{{end}}
+
+{{- define "bike.zpl" -}}
+^XA
+^CI28
+^PW457
+^LL254
+^FO20,15^A0N,28,28^FB417,1,0,L^FH\^FD{{zpl .Title}}^FS
+{{- if .Size}}
+^FO20,50^A0N,24,24^FH\^FDSize {{zpl .Size}}^FS
+{{- end}}
+^FO20,95^BY2{{template "zplBarcode" .}}
+^XZ
+{{end}}
+`))
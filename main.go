@@ -2,19 +2,34 @@ package main
 
 import (
 	"api/data"
+	"api/data/models"
+	"api/notify"
+	"api/reminders"
+	"context"
 	"database/sql"
 	_ "github.com/lib/pq"
 	"log"
 	"net/http"
 	"os"
+	"time"
 )
 
 var db *sql.DB
+var scheduler *reminders.Scheduler
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		runExportCommand(os.Args[2:])
+		return
+	}
+
 	router := addRoutes()
-	wrappedRouter := JSONWrapper{router}
+	wrappedRouter := JSONWrapper{&AuthWrapper{&IdempotencyWrapper{router}}}
 	db = initDB()
+	scheduler = initScheduler(db)
+	scheduler.Start(make(chan struct{}))
+	startPriceSchedule(db, make(chan struct{}))
+	startIdempotencyCleanup(db, idempotencyRetention(), make(chan struct{}))
 	server := &http.Server{
 		Addr:    ":8000",
 		Handler: &wrappedRouter,
@@ -28,5 +43,57 @@ func initDB() *sql.DB {
 		log.Fatal(err)
 	}
 	data.SetupDB(db)
+	createInitialUser(db)
 	return db
 }
+
+// createInitialUser creates an owner from ADMIN_USERNAME and ADMIN_PASSWORD when there are
+// no users yet, since every route except the index requires a login.
+func createInitialUser(db *sql.DB) {
+	count, err := data.CountUsers(context.Background(), db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	username, password := os.Getenv("ADMIN_USERNAME"), os.Getenv("ADMIN_PASSWORD")
+	if count > 0 || username == "" || password == "" {
+		return
+	}
+	_, err = data.CreateUser(context.Background(), db, models.User{Username: username, Name: username, Role: data.RoleOwner, Password: password})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("created initial user %s", username)
+}
+
+// initScheduler sets up the service reminder scheduler. Reminders are sent by email
+// when SMTP_ADDR is set and otherwise only logged.
+func initScheduler(db *sql.DB) *reminders.Scheduler {
+	var notifier notify.Notifier = notify.LogNotifier{}
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		notifier = notify.NewSMTPNotifier(addr, os.Getenv("SMTP_FROM"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	}
+
+	interval := time.Hour
+	if v := os.Getenv("REMINDER_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		interval = d
+	}
+	return reminders.NewScheduler(db, notifier, interval)
+}
+
+// idempotencyRetention is how long responses to requests with an Idempotency-Key are kept
+// for replay, set with IDEMPOTENCY_RETENTION and 24 hours by default
+func idempotencyRetention() time.Duration {
+	v := os.Getenv("IDEMPOTENCY_RETENTION")
+	if v == "" {
+		return 24 * time.Hour
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return d
+}
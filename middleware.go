@@ -1,6 +1,14 @@
 package main
 
-import "net/http"
+import (
+	"api/data"
+	"api/data/models"
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+)
 
 // JSONWrapper is a middleware handler that adds content type = json on all requests.
 type JSONWrapper struct {
@@ -18,3 +26,112 @@ func (l *JSONWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func NewJSONWrapper(handlerToWrap http.Handler) *JSONWrapper {
 	return &JSONWrapper{handlerToWrap}
 }
+
+type contextKey string
+
+const (
+	userKey   contextKey = "user"
+	apiKeyKey contextKey = "apikey"
+)
+
+// AuthWrapper is a middleware handler that rejects requests without a valid session token or API key.
+// Both are sent as "Authorization: Bearer <token>", and API keys are recognised by their prefix.
+// The index page and login are public.
+type AuthWrapper struct {
+	handler http.Handler
+}
+
+// ServeHTTP looks up the user of the session or the API key and passes it on in the request context
+func (a *AuthWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isPublic(r) {
+		a.handler.ServeHTTP(w, r)
+		return
+	}
+	token, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "authentication required")
+		return
+	}
+
+	var ctx context.Context
+	var err error
+	if strings.HasPrefix(token, data.ApiKeyPrefix) {
+		var apiKey models.ApiKey
+		apiKey, err = data.UseApiKey(r.Context(), db, token)
+		ctx = data.WithActor(context.WithValue(r.Context(), apiKeyKey, apiKey),
+			data.Actor{Name: "apikey:" + apiKey.Name, ApiKeyId: &apiKey.Id, Route: r.Method + " " + r.URL.Path})
+	} else {
+		var user models.User
+		user, err = data.GetSessionUser(r.Context(), db, token)
+		ctx = data.WithActor(context.WithValue(r.Context(), userKey, user),
+			data.Actor{Name: user.Username, UserId: &user.Id, Route: r.Method + " " + r.URL.Path})
+	}
+	if errors.Is(err, data.ErrInvalidSession) || errors.Is(err, data.ErrInvalidApiKey) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	a.handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// NewAuthWrapper constructs a new AuthWrapper middleware handler
+func NewAuthWrapper(handlerToWrap http.Handler) *AuthWrapper {
+	return &AuthWrapper{handlerToWrap}
+}
+
+func isPublic(r *http.Request) bool {
+	return (r.Method == http.MethodGet && r.URL.Path == "/") ||
+		(r.Method == http.MethodPost && r.URL.Path == "/login")
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// currentUser returns the logged in user of a request that has passed the AuthWrapper
+func currentUser(r *http.Request) (models.User, bool) {
+	user, ok := r.Context().Value(userKey).(models.User)
+	return user, ok
+}
+
+// currentApiKey returns the API key of a request that has passed the AuthWrapper
+func currentApiKey(r *http.Request) (models.ApiKey, bool) {
+	apiKey, ok := r.Context().Value(apiKeyKey).(models.ApiKey)
+	return apiKey, ok
+}
+
+// requires is a middleware that only lets the request through if the role of the
+// logged in user has been given the permission, or the API key has it as a scope
+func requires(permission string, handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, err := can(r, permission)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !ok {
+			writeProblem(w, r, http.StatusForbidden, CodeForbidden, "missing permission: "+permission)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// can reports whether the logged in user or API key of the request has the permission
+func can(r *http.Request, permission string) (bool, error) {
+	if apiKey, ok := currentApiKey(r); ok {
+		return slices.Contains(apiKey.Scopes, permission), nil
+	}
+	user, ok := currentUser(r)
+	if !ok {
+		return false, nil
+	}
+	return data.HasPermission(r.Context(), db, user.Role, permission)
+}
@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// Message is a single notification addressed to one recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to customers.
+type Notifier interface {
+	Notify(msg Message) error
+}
+
+// LogNotifier writes messages to the log instead of sending them.
+// It is used when no SMTP server has been configured.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(msg Message) error {
+	log.Printf("notify: to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// SMTPNotifier sends messages as plain text emails through an SMTP server.
+// Addr is host:port, and Username/Password are only used when Username is set,
+// which makes it possible to point the notifier at a local fake SMTP server.
+type SMTPNotifier struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// NewSMTPNotifier constructs a new SMTPNotifier
+func NewSMTPNotifier(addr string, from string, username string, password string) *SMTPNotifier {
+	return &SMTPNotifier{Addr: addr, From: from, Username: username, Password: password}
+}
+
+func (n *SMTPNotifier) Notify(msg Message) error {
+	var auth smtp.Auth
+	if n.Username != "" {
+		host, _, err := net.SplitHostPort(n.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+	return smtp.SendMail(n.Addr, auth, n.From, []string{msg.To}, n.format(msg))
+}
+
+func (n *SMTPNotifier) format(msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", header(n.From))
+	fmt.Fprintf(&b, "To: %s\r\n", header(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", header(msg.Subject))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// header strips line breaks so a value cannot inject extra headers
+func header(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeSMTP is an SMTP server on a local port that accepts a single message
+type fakeSMTP struct {
+	addr string
+	auth chan string
+	from chan string
+	to   chan string
+	data chan string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	s := &fakeSMTP{
+		addr: l.Addr().String(),
+		auth: make(chan string, 1),
+		from: make(chan string, 1),
+		to:   make(chan string, 1),
+		data: make(chan string, 1),
+	}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.serve(conn)
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost fake SMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			_, credentials, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(credentials)
+			s.auth <- string(decoded)
+			reply("235 authenticated")
+		case "MAIL":
+			s.from <- arg
+			reply("250 ok")
+		case "RCPT":
+			s.to <- arg
+			reply("250 ok")
+		case "DATA":
+			reply("354 end with .")
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				b.WriteString(l)
+			}
+			s.data <- b.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSMTPNotifierSendsMessage(t *testing.T) {
+	server := newFakeSMTP(t)
+	n := NewSMTPNotifier(server.addr, "shop@example.com", "shop", "secret")
+
+	err := n.Notify(Message{To: "ola@example.com", Subject: "Time for a service", Body: "Hi Ola,\nyour bike is due."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if auth := <-server.auth; auth != "\x00shop\x00secret" {
+		t.Errorf("auth = %q, want the username and password", auth)
+	}
+	if from := <-server.from; from != "FROM:<shop@example.com>" {
+		t.Errorf("MAIL %s, want FROM:<shop@example.com>", from)
+	}
+	if to := <-server.to; to != "TO:<ola@example.com>" {
+		t.Errorf("RCPT %s, want TO:<ola@example.com>", to)
+	}
+	data := <-server.data
+	for _, want := range []string{"To: ola@example.com\r\n", "Subject: Time for a service\r\n", "\r\n\r\nHi Ola,\r\nyour bike is due."} {
+		if !strings.Contains(data, want) {
+			t.Errorf("message does not contain %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestSMTPNotifierStripsHeaderInjection(t *testing.T) {
+	server := newFakeSMTP(t)
+	n := NewSMTPNotifier(server.addr, "shop@example.com", "", "")
+
+	err := n.Notify(Message{To: "ola@example.com", Subject: "Hello\r\nBcc: everyone@example.com", Body: "Hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := <-server.data
+	if strings.Contains(data, "\r\nBcc:") {
+		t.Errorf("the subject added a header:\n%s", data)
+	}
+	if !strings.Contains(data, "Subject: HelloBcc: everyone@example.com\r\n") {
+		t.Errorf("message has no subject on one line:\n%s", data)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+)
+
+const mergePatchType = "application/merge-patch+json"
+
+var errUnsupportedPatch = errors.New("a PATCH body must be a JSON merge patch (" + mergePatchType + ")")
+
+// decodeMergePatch applies the RFC 7396 merge patch in the request body to current and
+// decodes the result into target. Fields missing from the patch keep their current value
+// and fields set to null are cleared.
+func decodeMergePatch(r *http.Request, current any, target any) error {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mediaType != mergePatchType && mediaType != "application/json") {
+			return errUnsupportedPatch
+		}
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var patch any
+	if err := json.Unmarshal(body, &patch); err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	var doc any
+	if err := json.Unmarshal(j, &doc); err != nil {
+		return err
+	}
+	j, err = json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(j, target)
+}
+
+// mergePatch is the MergePatch function of RFC 7396
+func mergePatch(target any, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+		} else {
+			t[name] = mergePatch(t[name], value)
+		}
+	}
+	return t
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"api/data"
+	"api/data/models"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Functions for the price history of products and scheduled price changes
+
+// getPriceHistoryHandler returns every price the product has had, and the scheduled changes, latest first
+func getPriceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	history, err := data.GetPriceHistory(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, history)
+}
+
+// schedulePriceChangeHandler schedules a new price for the product, e.g.
+// {"price": 899, "effective": "2027-03-01T00:00:00+01:00"} for the start of the season
+func schedulePriceChangeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var change models.PriceChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	change.ProductId = id
+	changeId, err := data.SchedulePriceChange(r.Context(), db, change)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	created, err := data.GetPriceChange(r.Context(), db, id, changeId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeCreated(w, r, fmt.Sprintf("/products/%d/price-changes/%d", id, changeId), created)
+}
+
+func getPriceChangeHandler(w http.ResponseWriter, r *http.Request) {
+	id, changeId, err := priceChangePath(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	change, err := data.GetPriceChange(r.Context(), db, id, changeId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, change)
+}
+
+// cancelPriceChangeHandler deletes a scheduled price change before it takes effect
+func cancelPriceChangeHandler(w http.ResponseWriter, r *http.Request) {
+	id, changeId, err := priceChangePath(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := data.CancelPriceChange(r.Context(), db, id, changeId); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeNoContent(w)
+}
+
+// priceChangePath reads the product id and change id of /products/{id}/price-changes/{changeId}
+func priceChangePath(r *http.Request) (int, int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return -1, -1, err
+	}
+	changeId, err := strconv.Atoi(r.PathValue("changeId"))
+	if err != nil {
+		return -1, -1, err
+	}
+	return id, changeId, nil
+}
+
+// startPriceSchedule applies the scheduled price changes that have taken effect in the background,
+// every PRICE_SCHEDULE_INTERVAL and every minute by default, until stop is closed
+func startPriceSchedule(db *sql.DB, stop <-chan struct{}) {
+	interval := time.Minute
+	if v := os.Getenv("PRICE_SCHEDULE_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		interval = d
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			applied, err := data.ApplyDuePriceChanges(ctx, db)
+			if err != nil {
+				log.Println("prices:", err)
+			}
+			for _, change := range applied {
+				log.Printf("prices: product %d costs %.2f from %s", change.ProductId, change.Price, change.Effective.Format(time.RFC3339))
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
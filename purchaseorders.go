@@ -0,0 +1,167 @@
+package main
+
+import (
+	"api/data"
+	"api/data/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Functions for purchase orders to manufacturers and receiving goods against them
+
+// createPurchaseOrderHandler creates a draft purchase order, e.g. {"manufacturerId": 3,
+// "expectedDelivery": "2024-05-01", "lines": [{"productId": 7, "quantity": 10}]}.
+// Lines without a unitCost are ordered at the cost price of the manufacturer.
+func createPurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var order models.PurchaseOrder
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	id, err := data.CreatePurchaseOrder(r.Context(), db, order)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	order, err = data.GetPurchaseOrder(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(order.Version))
+	writeCreated(w, r, fmt.Sprintf("/purchaseorders/%d", id), order)
+}
+
+func getPurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	order, err := data.GetPurchaseOrder(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTagged(w, r, http.StatusOK, order.Version, order)
+}
+
+// getPurchaseOrdersHandler lists purchase orders without their lines, filtered by ?manufacturer=, ?status= and ?product=
+func getPurchaseOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := listQuery(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	orders, err := data.GetPurchaseOrders(r.Context(), db, q)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, orders)
+}
+
+// updatePurchaseOrderHandler replaces a draft purchase order, including all of its lines
+func updatePurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var order models.PurchaseOrder
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before, err := data.GetPurchaseOrder(r.Context(), db, order.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	order.Version, err = ifMatch(r, before.Version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if _, err := data.UpdatePurchaseOrder(r.Context(), db, order); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	after, err := data.GetPurchaseOrder(r.Context(), db, order.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTagged(w, r, http.StatusOK, after.Version, after)
+}
+
+func deletePurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before, err := data.GetPurchaseOrder(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r, before.Version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = data.DeletePurchaseOrder(r.Context(), db, id, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeNoContent(w)
+}
+
+// purchaseOrderStatusHandler moves the purchase order on to another status with change
+func purchaseOrderStatusHandler(change func(r *http.Request, id int) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if err := change(r, id); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		after, err := data.GetPurchaseOrder(r.Context(), db, id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeTagged(w, r, http.StatusOK, after.Version, after)
+	}
+}
+
+var sendPurchaseOrderHandler = purchaseOrderStatusHandler(func(r *http.Request, id int) error {
+	return data.SendPurchaseOrder(r.Context(), db, id)
+})
+
+var cancelPurchaseOrderHandler = purchaseOrderStatusHandler(func(r *http.Request, id int) error {
+	return data.CancelPurchaseOrder(r.Context(), db, id)
+})
+
+// receivePurchaseOrderHandler receives goods against lines of the purchase order and posts them
+// as inventory movements, e.g. [{"lineId": 12, "quantity": 4}]
+func receivePurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var lines []models.ReceivedLine
+	if err := json.NewDecoder(r.Body).Decode(&lines); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	receipt, err := data.ReceivePurchaseOrder(r.Context(), db, id, lines)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTagged(w, r, http.StatusOK, receipt.Order.Version, receipt)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"api/data"
+	"api/data/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Functions for manipulating service reminder rules
+func createReminderRuleHandler(w http.ResponseWriter, r *http.Request) {
+	rule := models.ReminderRule{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	id, err := data.CreateReminderRule(r.Context(), db, rule)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	rule.Id = id
+	writeCreated(w, r, fmt.Sprintf("/reminders/rules/%d", id), rule)
+}
+
+func getReminderRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	rule, err := data.GetReminderRule(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, rule)
+}
+
+func getReminderRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := data.GetReminderRules(r.Context(), db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, rules)
+}
+
+func updateReminderRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var rule models.ReminderRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err := data.UpdateReminderRule(r.Context(), db, rule)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	rule, err = data.GetReminderRule(r.Context(), db, rule.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, rule)
+}
+
+func deleteReminderRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = data.DeleteReminderRule(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeNoContent(w)
+}
+
+func getRemindersHandler(w http.ResponseWriter, r *http.Request) {
+	reminders, err := data.GetReminders(r.Context(), db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, reminders)
+}
+
+// runRemindersHandler sends the due reminders right away instead of waiting for the scheduler
+func runRemindersHandler(w http.ResponseWriter, r *http.Request) {
+	sent, err := scheduler.Run(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, sent)
+}
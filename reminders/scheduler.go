@@ -0,0 +1,124 @@
+package reminders
+
+import (
+	"api/data"
+	"api/data/models"
+	"api/notify"
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// Scheduler periodically looks for bikes that are due a service reminder
+// and sends a message to the owner through the configured Notifier.
+type Scheduler struct {
+	db       *sql.DB
+	notifier notify.Notifier
+	interval time.Duration
+}
+
+// NewScheduler constructs a new Scheduler checking for due reminders every interval
+func NewScheduler(db *sql.DB, notifier notify.Notifier, interval time.Duration) *Scheduler {
+	return &Scheduler{db: db, notifier: notifier, interval: interval}
+}
+
+// Start runs the scheduler in the background until stop is closed, which also cancels a running check
+func (s *Scheduler) Start(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			if _, err := s.Run(ctx); err != nil {
+				log.Println("reminders:", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Run sends all reminders that are currently due and returns the ones that were sent.
+// A failure to notify a single owner is logged and does not stop the remaining reminders.
+func (s *Scheduler) Run(ctx context.Context) ([]models.Reminder, error) {
+	var sent []models.Reminder
+	rules, err := data.GetReminderRules(ctx, s.db)
+	if err != nil {
+		return sent, err
+	}
+
+	for _, rule := range rules {
+		if !rule.Active {
+			continue
+		}
+		due, err := data.GetDueReminders(ctx, s.db, rule)
+		if err != nil {
+			return sent, err
+		}
+		for _, d := range due {
+			if d.Owner.Email == "" {
+				continue
+			}
+			// the reminder is logged and the owner notified in one transaction, which
+			// is rolled back when the notification fails so that it is tried again
+			reminder := models.Reminder{
+				RuleId:      rule.Id,
+				FrameNumber: d.FrameNumber,
+				CustomerId:  d.Owner.Id,
+				Email:       d.Owner.Email,
+			}
+			var claimed bool
+			var notifyErr error
+			err := data.InTx(ctx, s.db, func(tx data.DBTX) error {
+				var err error
+				claimed, err = data.ClaimReminder(ctx, tx, rule, d.FrameNumber)
+				if err != nil || !claimed {
+					return err
+				}
+				reminder.Id, err = data.CreateReminder(ctx, tx, reminder)
+				if err != nil {
+					return err
+				}
+				notifyErr = s.notifier.Notify(Message(d))
+				return notifyErr
+			})
+			if notifyErr != nil {
+				log.Printf("reminders: notifying %s about bike %s: %v", d.Owner.Email, d.FrameNumber, notifyErr)
+				continue
+			}
+			if err != nil {
+				return sent, err
+			}
+			if !claimed {
+				continue
+			}
+			sent = append(sent, reminder)
+		}
+	}
+	return sent, nil
+}
+
+// Message builds the message for a due reminder by filling in the placeholders
+// {firstName}, {lastName}, {frameNumber} and {lastEvent} in the rule's subject and body.
+func Message(d models.DueReminder) notify.Message {
+	r := strings.NewReplacer(
+		"{firstName}", d.Owner.FirstName,
+		"{lastName}", d.Owner.LastName,
+		"{frameNumber}", d.FrameNumber,
+		"{lastEvent}", d.LastEvent.Format("2006-01-02"),
+	)
+	return notify.Message{
+		To:      d.Owner.Email,
+		Subject: r.Replace(d.Rule.Subject),
+		Body:    r.Replace(d.Rule.Body),
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"api/data/models"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// writeJSON marshals v and writes it with the given status
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(j)
+}
+
+// writeNoContent writes 204 without the JSON content type, since there is no body
+func writeNoContent(w http.ResponseWriter) {
+	w.Header().Del("Content-Type")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeCreated writes the created resource with 201 and a Location header pointing at it
+func writeCreated(w http.ResponseWriter, r *http.Request, location string, v any) {
+	w.Header().Set("Location", location)
+	writeJSON(w, r, http.StatusCreated, v)
+}
+
+// listQuery reads the filters, sort, limit and cursor of a list endpoint from the query string,
+// e.g. ?color=red&min_price=100&sort=-price&limit=20&cursor=...
+func listQuery(r *http.Request) (models.ListQuery, error) {
+	q := models.ListQuery{Filters: map[string]string{}}
+	for name, values := range r.URL.Query() {
+		value := values[len(values)-1]
+		switch name {
+		case "sort":
+			q.Sort = value
+		case "cursor":
+			q.Cursor = value
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil {
+				return q, err
+			}
+			q.Limit = limit
+		case "category":
+			// the ids are cast in the query, where a bad one would fail in the database
+			for _, id := range strings.Split(value, ",") {
+				if _, err := strconv.Atoi(strings.TrimSpace(id)); err != nil {
+					return q, err
+				}
+			}
+			q.Filters[name] = value
+		default:
+			q.Filters[name] = value
+		}
+	}
+	return q, nil
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 )
 
@@ -13,38 +14,147 @@ func addRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /", indexHandler)
 
-	mux.HandleFunc("POST /products", createProductHandler)
-	mux.HandleFunc("GET /products/{id}", getProductHandler)
-	mux.HandleFunc("GET /products", getProductsHandler)
-	mux.HandleFunc("GET /products/size", getProductsBySizeHandler)
-	mux.HandleFunc("GET /products/color", getProductsByColorHandler)
-	mux.HandleFunc("GET /products/name", getProductsByNameHandler)
-	mux.HandleFunc("PUT /products", updateProductHandler)
-	mux.HandleFunc("DELETE /products/{id}", deleteProductHandler)
-
-	mux.HandleFunc("POST /customers", createCustomerHandler)
-	mux.HandleFunc("GET /customers/{id}", getCustomerHandler)
-	mux.HandleFunc("GET /customers", getCustomersHandler)
-	mux.HandleFunc("PUT /customers", updateCustomerHandler)
-	mux.HandleFunc("DELETE /customers/{id}", deleteCustomerHandler)
-
-	mux.HandleFunc("POST /manufacturers", createManufacturerHandler)
-	mux.HandleFunc("GET /manufacturers/{id}", getManufacturerHandler)
-	mux.HandleFunc("GET /manufacturers", getManufacturersHandler)
-	mux.HandleFunc("PUT /manufacturers", updateManufacturerHandler)
-	mux.HandleFunc("DELETE /manufacturers/{id}", deleteManufacturerHandler)
-
-	mux.HandleFunc("POST /products/{id}/manufacturers", associateManufacturersHandler)
-	mux.HandleFunc("DELETE /products/{id}/manufacturers", removeAssociatedManufacturersHandler)
-
-	mux.HandleFunc("POST /bikes", createBikeHandler)
-	mux.HandleFunc("GET /bikes/{framenumber}", getBikeHandler)
-	mux.HandleFunc("GET /bikes", getBikesHandler)
-	mux.HandleFunc("DELETE /bikes/{framenumber}", deleteBikeHandler)
-
-	mux.HandleFunc("POST /bikes/{framenumber}/owner", addOwner)
-	mux.HandleFunc("DELETE /bikes/{framenumber}/owner", deleteOwner)
-	return mux
+	mux.HandleFunc("POST /login", loginHandler)
+	mux.HandleFunc("POST /logout", logoutHandler)
+	mux.HandleFunc("GET /me", getMeHandler)
+
+	mux.Handle("GET /roles", requires(data.PermRolesManage, getRolesHandler))
+	mux.Handle("PUT /roles/{role}", requires(data.PermRolesManage, updateRoleHandler))
+
+	mux.Handle("POST /apikeys", requires(data.PermApiKeysManage, createApiKeyHandler))
+	mux.Handle("GET /apikeys", requires(data.PermApiKeysManage, getApiKeysHandler))
+	mux.Handle("DELETE /apikeys/{id}", requires(data.PermApiKeysManage, revokeApiKeyHandler))
+
+	mux.Handle("GET /audit", requires(data.PermAuditRead, getAuditHandler))
+
+	mux.Handle("POST /users", requires(data.PermUsersManage, createUserHandler))
+	mux.Handle("GET /users/{id}", requires(data.PermUsersManage, getUserHandler))
+	mux.Handle("GET /users", requires(data.PermUsersManage, getUsersHandler))
+	mux.Handle("PUT /users", requires(data.PermUsersManage, updateUserHandler))
+	mux.Handle("DELETE /users/{id}", requires(data.PermUsersManage, deleteUserHandler))
+
+	mux.Handle("POST /products", requires(data.PermProductsWrite, createProductHandler))
+	mux.Handle("POST /products/import", requires(data.PermProductsWrite, importProductsHandler))
+	mux.Handle("GET /products/{id}", requires(data.PermProductsRead, getProductHandler))
+	mux.Handle("GET /products", requires(data.PermProductsRead, getProductsHandler))
+	mux.Handle("PUT /products", requires(data.PermProductsWrite, updateProductHandler))
+	mux.Handle("PATCH /products/{id}", requires(data.PermProductsWrite, patchProductHandler))
+	mux.Handle("DELETE /products/{id}", requires(data.PermProductsDelete, deleteProductHandler))
+
+	mux.Handle("GET /search", requires(data.PermProductsRead, searchHandler))
+
+	mux.Handle("POST /customers", requires(data.PermCustomersWrite, createCustomerHandler))
+	mux.Handle("GET /customers/{id}", requires(data.PermCustomersRead, getCustomerHandler))
+	mux.Handle("GET /customers", requires(data.PermCustomersRead, getCustomersHandler))
+	mux.Handle("PUT /customers", requires(data.PermCustomersWrite, updateCustomerHandler))
+	mux.Handle("PATCH /customers/{id}", requires(data.PermCustomersWrite, patchCustomerHandler))
+	mux.Handle("DELETE /customers/{id}", requires(data.PermCustomersDelete, deleteCustomerHandler))
+
+	mux.Handle("POST /manufacturers", requires(data.PermManufacturersWrite, createManufacturerHandler))
+	mux.Handle("GET /manufacturers/{id}", requires(data.PermManufacturersRead, getManufacturerHandler))
+	mux.Handle("GET /manufacturers", requires(data.PermManufacturersRead, getManufacturersHandler))
+	mux.Handle("PUT /manufacturers", requires(data.PermManufacturersWrite, updateManufacturerHandler))
+	mux.Handle("PATCH /manufacturers/{id}", requires(data.PermManufacturersWrite, patchManufacturerHandler))
+	mux.Handle("DELETE /manufacturers/{id}", requires(data.PermManufacturersDelete, deleteManufacturerHandler))
+
+	mux.Handle("POST /products/{id}/manufacturers", requires(data.PermProductsWrite, associateManufacturersHandler))
+	mux.Handle("DELETE /products/{id}/manufacturers", requires(data.PermProductsWrite, removeAssociatedManufacturersHandler))
+	mux.Handle("PUT /products/{id}/manufacturers/{manufacturerId}", requires(data.PermCostsWrite, setCostPriceHandler))
+	mux.Handle("GET /products/{id}/costs", requires(data.PermCostsRead, getProductCostHandler))
+	mux.Handle("POST /products/{id}/receipts", requires(data.PermInventoryReceive, receiveGoodsHandler))
+	mux.Handle("GET /products/{id}/movements", requires(data.PermCostsRead, getInventoryMovementsHandler))
+	mux.Handle("GET /reports/margins", requires(data.PermCostsRead, getMarginReportHandler))
+
+	mux.Handle("POST /purchaseorders", requires(data.PermPurchasingManage, createPurchaseOrderHandler))
+	mux.Handle("GET /purchaseorders/{id}", requires(data.PermPurchasingRead, getPurchaseOrderHandler))
+	mux.Handle("GET /purchaseorders", requires(data.PermPurchasingRead, getPurchaseOrdersHandler))
+	mux.Handle("PUT /purchaseorders", requires(data.PermPurchasingManage, updatePurchaseOrderHandler))
+	mux.Handle("DELETE /purchaseorders/{id}", requires(data.PermPurchasingManage, deletePurchaseOrderHandler))
+	mux.Handle("POST /purchaseorders/{id}/send", requires(data.PermPurchasingManage, sendPurchaseOrderHandler))
+	mux.Handle("POST /purchaseorders/{id}/cancel", requires(data.PermPurchasingManage, cancelPurchaseOrderHandler))
+	mux.Handle("POST /purchaseorders/{id}/receipts", requires(data.PermInventoryReceive, receivePurchaseOrderHandler))
+
+	mux.Handle("POST /products/{id}/variants", requires(data.PermProductsWrite, createVariantHandler))
+	mux.Handle("GET /products/{id}/variants/{variantId}", requires(data.PermProductsRead, getVariantHandler))
+	mux.Handle("GET /products/{id}/variants", requires(data.PermProductsRead, getVariantsHandler))
+	mux.Handle("PUT /products/{id}/variants/{variantId}", requires(data.PermProductsWrite, updateVariantHandler))
+	mux.Handle("DELETE /products/{id}/variants/{variantId}", requires(data.PermProductsDelete, deleteVariantHandler))
+	mux.Handle("GET /products/{id}/barcode", requires(data.PermProductsRead, getProductBarcodeHandler))
+	mux.Handle("GET /products/{id}/label", requires(data.PermProductsRead, getProductLabelHandler))
+	mux.Handle("GET /products/{id}/price-history", requires(data.PermProductsRead, getPriceHistoryHandler))
+	mux.Handle("POST /products/{id}/price-changes", requires(data.PermProductsPrice, schedulePriceChangeHandler))
+	mux.Handle("GET /products/{id}/price-changes/{changeId}", requires(data.PermProductsRead, getPriceChangeHandler))
+	mux.Handle("DELETE /products/{id}/price-changes/{changeId}", requires(data.PermProductsPrice, cancelPriceChangeHandler))
+
+	mux.Handle("GET /products/{id}/categories", requires(data.PermProductsRead, getProductCategoriesHandler))
+	mux.Handle("POST /products/{id}/categories", requires(data.PermProductsWrite, addProductCategoriesHandler))
+	mux.Handle("DELETE /products/{id}/categories", requires(data.PermProductsWrite, removeProductCategoriesHandler))
+
+	mux.Handle("POST /categories", requires(data.PermProductsWrite, createCategoryHandler))
+	mux.Handle("GET /categories/{id}", requires(data.PermProductsRead, getCategoryHandler))
+	mux.Handle("GET /categories", requires(data.PermProductsRead, getCategoriesHandler))
+	mux.Handle("PUT /categories", requires(data.PermProductsWrite, updateCategoryHandler))
+	mux.Handle("DELETE /categories/{id}", requires(data.PermProductsDelete, deleteCategoryHandler))
+	mux.Handle("GET /categories/{id}/products", requires(data.PermProductsRead, getCategoryProductsHandler))
+	mux.Handle("GET /reports/categories", requires(data.PermReportsRead, getCategoryReportHandler))
+
+	mux.Handle("POST /bikes", requires(data.PermBikesWrite, createBikeHandler))
+	mux.Handle("GET /bikes/{framenumber}", requires(data.PermBikesRead, getBikeHandler))
+	mux.Handle("GET /bikes", requires(data.PermBikesRead, getBikesHandler))
+	mux.Handle("PATCH /bikes/{framenumber}", requires(data.PermBikesWrite, patchBikeHandler))
+	mux.Handle("DELETE /bikes/{framenumber}", requires(data.PermBikesDelete, deleteBikeHandler))
+
+	mux.Handle("POST /bikes/{framenumber}/owner", requires(data.PermBikesWrite, addOwner))
+	mux.Handle("DELETE /bikes/{framenumber}/owner", requires(data.PermBikesWrite, deleteOwner))
+	mux.Handle("GET /bikes/{framenumber}/label", requires(data.PermBikesRead, getBikeLabelHandler))
+
+	mux.Handle("GET /export/products", requires(data.PermProductsRead, exportHandler("products")))
+	mux.Handle("GET /export/customers", requires(data.PermCustomersRead, exportHandler("customers")))
+	mux.Handle("GET /export/manufacturers", requires(data.PermManufacturersRead, exportHandler("manufacturers")))
+	mux.Handle("GET /export/bikes", requires(data.PermBikesRead, exportHandler("bikes")))
+
+	mux.Handle("POST /workcards", requires(data.PermWorkcardsWrite, createWorkcardHandler))
+	mux.Handle("GET /workcards/{id}", requires(data.PermWorkcardsRead, getWorkcardHandler))
+	mux.Handle("GET /workcards", requires(data.PermWorkcardsRead, getWorkcardsHandler))
+	mux.Handle("PUT /workcards", requires(data.PermWorkcardsWrite, updateWorkcardHandler))
+	mux.Handle("POST /workcards/{id}/clockin", requires(data.PermTimeTrack, clockInHandler))
+	mux.Handle("POST /workcards/{id}/clockout", requires(data.PermTimeTrack, clockOutHandler))
+	mux.Handle("GET /workcards/{id}/time", requires(data.PermWorkcardsRead, getWorkcardTimeHandler))
+	mux.Handle("GET /reports/productivity", requires(data.PermReportsRead, getProductivityHandler))
+
+	mux.Handle("POST /reminders/rules", requires(data.PermRemindersManage, createReminderRuleHandler))
+	mux.Handle("GET /reminders/rules/{id}", requires(data.PermRemindersManage, getReminderRuleHandler))
+	mux.Handle("GET /reminders/rules", requires(data.PermRemindersManage, getReminderRulesHandler))
+	mux.Handle("PUT /reminders/rules", requires(data.PermRemindersManage, updateReminderRuleHandler))
+	mux.Handle("DELETE /reminders/rules/{id}", requires(data.PermRemindersManage, deleteReminderRuleHandler))
+	mux.Handle("GET /reminders", requires(data.PermRemindersManage, getRemindersHandler))
+	mux.Handle("POST /reminders/run", requires(data.PermRemindersManage, runRemindersHandler))
+
+	mux.Handle("POST /mechanics", requires(data.PermWorkshopManage, createMechanicHandler))
+	mux.Handle("GET /mechanics/{id}", requires(data.PermAppointmentsRead, getMechanicHandler))
+	mux.Handle("GET /mechanics", requires(data.PermAppointmentsRead, getMechanicsHandler))
+	mux.Handle("PUT /mechanics", requires(data.PermWorkshopManage, updateMechanicHandler))
+	mux.Handle("DELETE /mechanics/{id}", requires(data.PermWorkshopManage, deleteMechanicHandler))
+
+	mux.Handle("POST /servicetypes", requires(data.PermWorkshopManage, createServiceTypeHandler))
+	mux.Handle("GET /servicetypes/{id}", requires(data.PermAppointmentsRead, getServiceTypeHandler))
+	mux.Handle("GET /servicetypes", requires(data.PermAppointmentsRead, getServiceTypesHandler))
+	mux.Handle("PUT /servicetypes", requires(data.PermWorkshopManage, updateServiceTypeHandler))
+	mux.Handle("DELETE /servicetypes/{id}", requires(data.PermWorkshopManage, deleteServiceTypeHandler))
+
+	mux.Handle("GET /appointments/slots", requires(data.PermAppointmentsRead, getSlotsHandler))
+	mux.Handle("POST /appointments", requires(data.PermAppointmentsWrite, bookAppointmentHandler))
+	mux.Handle("GET /appointments/{id}", requires(data.PermAppointmentsRead, getAppointmentHandler))
+	mux.Handle("GET /appointments", requires(data.PermAppointmentsRead, getAppointmentsHandler))
+	mux.Handle("DELETE /appointments/{id}", requires(data.PermAppointmentsWrite, cancelAppointmentHandler))
+	mux.Handle("POST /appointments/{id}/dropoff", requires(data.PermWorkcardsWrite, dropOffHandler))
+
+	// "by-barcode" is also a valid {id}, so the lookup conflicts with the /products/{id}/... routes
+	// and is routed before the other routes instead of next to them
+	router := http.NewServeMux()
+	router.Handle("/", mux)
+	router.Handle("GET /products/by-barcode/{code}", requires(data.PermProductsRead, getProductByBarcodeHandler))
+	return router
 }
 
 func indexHandler(w http.ResponseWriter, r *http.Request) {
@@ -55,401 +165,589 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 func createProductHandler(w http.ResponseWriter, r *http.Request) {
 	var product models.Product
 	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	id, err := data.CreateProduct(db, &product)
+	id, err := data.CreateProduct(r.Context(), db, &product)
 	if err != nil || id == -1 {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte(fmt.Sprintf("Product created successfully - Product Id: %d", id)))
-}
-
-func getProductHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(r.PathValue("id"))
+	searchIndex.Invalidate()
+	product, err = data.GetProduct(r.Context(), db, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
+	w.Header().Set("ETag", etag(product.Version))
+	writeCreated(w, r, fmt.Sprintf("/products/%d", id), product)
+}
 
-	product, err := data.GetProduct(db, id)
+func getProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	j, err := json.Marshal(product)
+	product, err := data.GetProduct(r.Context(), db, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
+	writeTagged(w, r, http.StatusOK, product.Version, product)
 }
 
 func getProductsHandler(w http.ResponseWriter, r *http.Request) {
-	products, err := data.GetProducts(db)
+	q, err := listQuery(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	j, err := json.Marshal(products)
+	products, err := data.GetProducts(r.Context(), db, q)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
+	writeJSON(w, r, http.StatusOK, products)
 }
 
-func getProductsBySizeHandler(w http.ResponseWriter, r *http.Request) {
-	var body map[string]string
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+func updateProductHandler(w http.ResponseWriter, r *http.Request) {
+	var product models.Product
+	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+		writeError(w, r, err)
+		return
 	}
-	products, err := data.GetProductsBySize(db, body["size"])
+
+	current, err := data.GetProduct(r.Context(), db, product.Id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
-	j, err := json.Marshal(products)
+	ok, err := canChangePrice(r, current, product)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+	if !ok {
+		writeProblem(w, r, http.StatusForbidden, CodeForbidden, "missing permission: "+data.PermProductsPrice)
+		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
-}
 
-func getProductsByColorHandler(w http.ResponseWriter, r *http.Request) {
-	var body map[string]string
-	err := json.NewDecoder(r.Body).Decode(&body)
+	product.Version, err = ifMatch(r, current.Version)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
+		return
 	}
-	products, err := data.GetProductsByColor(db, body["color"])
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if _, err := data.UpdateProduct(r.Context(), db, product); err != nil {
+		writeError(w, r, err)
+		return
 	}
-	j, err := json.Marshal(products)
+	searchIndex.Invalidate()
+	after, err := data.GetProduct(r.Context(), db, product.Id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
+	writeTagged(w, r, http.StatusOK, after.Version, after)
 }
 
-func getProductsByNameHandler(w http.ResponseWriter, r *http.Request) {
-	var body map[string]string
-	err := json.NewDecoder(r.Body).Decode(&body)
+// patchProductHandler applies a JSON merge patch, e.g. {"price": 899}, to the product
+func patchProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
+		return
 	}
-	products, err := data.GetProductsByName(db, body["name"])
+	current, err := data.GetProduct(r.Context(), db, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
-	j, err := json.Marshal(products)
+	version, err := ifMatch(r, current.Version)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
-}
-
-func updateProductHandler(w http.ResponseWriter, r *http.Request) {
 	var product models.Product
-	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := decodeMergePatch(r, current, &product); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	product.Id, product.Version = id, version
+
+	ok, err := canChangePrice(r, current, product)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !ok {
+		writeProblem(w, r, http.StatusForbidden, CodeForbidden, "missing permission: "+data.PermProductsPrice)
 		return
 	}
 
-	err := data.UpdateProduct(db, product)
+	if _, err := data.UpdateProduct(r.Context(), db, product); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	searchIndex.Invalidate()
+	after, err := data.GetProduct(r.Context(), db, product.Id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Product updated successfully")))
+	writeTagged(w, r, http.StatusOK, after.Version, after)
+}
+
+// canChangePrice reports whether the update from current to product is allowed to change
+// the price, which needs its own permission
+func canChangePrice(r *http.Request, current models.Product, product models.Product) (bool, error) {
+	if current.Price == product.Price {
+		return true, nil
+	}
+	return can(r, data.PermProductsPrice)
 }
 
 func deleteProductHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
+		return
+	}
+	before, err := data.GetProduct(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	err = data.DeleteProduct(db, id)
+	version, err := ifMatch(r, before.Version)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Product deleted successfully - Product Id: %d", id)))
+	err = data.DeleteProduct(r.Context(), db, id, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	searchIndex.Invalidate()
+	writeNoContent(w)
 }
 
 // Functions for manipulating customers
 func createCustomerHandler(w http.ResponseWriter, r *http.Request) {
 	var customer models.Customer
 	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+	id, err := data.CreateCustomer(r.Context(), db, customer)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	id, err := data.CreateCustomer(db, customer)
+	customer, err = data.GetCustomer(r.Context(), db, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte(fmt.Sprintf("Customer created successfully - Customer Id: %d", id)))
+	w.Header().Set("ETag", etag(customer.Version))
+	writeCreated(w, r, fmt.Sprintf("/customers/%d", id), customer)
 }
 
 func getCustomerHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	customer, err := data.GetCustomer(db, id)
+	customer, err := data.GetCustomer(r.Context(), db, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
+	writeTagged(w, r, http.StatusOK, customer.Version, customer)
+}
 
-	j, err := json.Marshal(customer)
+func getCustomersHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := listQuery(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
+	customers, err := data.GetCustomers(r.Context(), db, q)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, customers)
 }
 
-func getCustomersHandler(w http.ResponseWriter, r *http.Request) {
-	customers, err := data.GetCustomers(db)
+func updateCustomerHandler(w http.ResponseWriter, r *http.Request) {
+	var customer models.Customer
+	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	before, err := data.GetCustomer(r.Context(), db, customer.Id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	j, err := json.Marshal(customers)
+	customer.Version, err = ifMatch(r, before.Version)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
+	if _, err := data.UpdateCustomer(r.Context(), db, customer); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	after, err := data.GetCustomer(r.Context(), db, customer.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTagged(w, r, http.StatusOK, after.Version, after)
 }
 
-func updateCustomerHandler(w http.ResponseWriter, r *http.Request) {
+// patchCustomerHandler applies a JSON merge patch, e.g. {"address": {"city": "Oslo"}}, to the customer
+func patchCustomerHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before, err := data.GetCustomer(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r, before.Version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 	var customer models.Customer
-	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := decodeMergePatch(r, before, &customer); err != nil {
+		writeError(w, r, err)
 		return
 	}
+	customer.Id, customer.Version = id, version
 
-	err := data.UpdateCustomer(db, customer)
+	if _, err := data.UpdateCustomer(r.Context(), db, customer); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	after, err := data.GetCustomer(r.Context(), db, customer.Id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Customer updated successfully")))
+	writeTagged(w, r, http.StatusOK, after.Version, after)
 }
 
 func deleteCustomerHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
+		return
+	}
+	before, err := data.GetCustomer(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r, before.Version)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	err = data.DeleteCustomer(db, id)
+	err = data.DeleteCustomer(r.Context(), db, id, version)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Customer deleted successfully - Customer Id: %d", id)))
+	writeNoContent(w)
 }
 
 func createManufacturerHandler(w http.ResponseWriter, r *http.Request) {
 	var manufacturer models.Manufacturer
 	if err := json.NewDecoder(r.Body).Decode(&manufacturer); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+	id, err := data.CreateManufacturer(r.Context(), db, manufacturer)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	id, err := data.CreateManufacturer(db, manufacturer)
+	manufacturer, err = data.GetManufacturer(r.Context(), db, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte(fmt.Sprintf("Manufacturer successfully created - Manufacturer Id: %d", id)))
+	w.Header().Set("ETag", etag(manufacturer.Version))
+	writeCreated(w, r, fmt.Sprintf("/manufacturers/%d", id), manufacturer)
 }
 
 func getManufacturerHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	manufacturer, err := data.GetManufacturer(db, id)
+	manufacturer, err := data.GetManufacturer(r.Context(), db, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	j, err := json.Marshal(manufacturer)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
+	writeTagged(w, r, http.StatusOK, manufacturer.Version, manufacturer)
 }
 
 func getManufacturersHandler(w http.ResponseWriter, r *http.Request) {
-	manufacturers, err := data.GetManufacturers(db)
+	q, err := listQuery(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	j, err := json.Marshal(manufacturers)
+	manufacturers, err := data.GetManufacturers(r.Context(), db, q)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
+	writeJSON(w, r, http.StatusOK, manufacturers)
 }
 
 func updateManufacturerHandler(w http.ResponseWriter, r *http.Request) {
 	var manufacturer models.Manufacturer
 	if err := json.NewDecoder(r.Body).Decode(&manufacturer); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+	before, err := data.GetManufacturer(r.Context(), db, manufacturer.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	manufacturer.Version, err = ifMatch(r, before.Version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if _, err := data.UpdateManufacturer(r.Context(), db, manufacturer); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	searchIndex.Invalidate()
+	after, err := data.GetManufacturer(r.Context(), db, manufacturer.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTagged(w, r, http.StatusOK, after.Version, after)
+}
+
+// patchManufacturerHandler applies a JSON merge patch, e.g. {"phone": "+4712345678"}, to the manufacturer
+func patchManufacturerHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before, err := data.GetManufacturer(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r, before.Version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var manufacturer models.Manufacturer
+	if err := decodeMergePatch(r, before, &manufacturer); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	err := data.UpdateManufacturer(db, manufacturer)
+	manufacturer.Id, manufacturer.Version = id, version
+
+	if _, err := data.UpdateManufacturer(r.Context(), db, manufacturer); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	searchIndex.Invalidate()
+	after, err := data.GetManufacturer(r.Context(), db, manufacturer.Id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Manufacturer updated successfully")))
+	writeTagged(w, r, http.StatusOK, after.Version, after)
 }
 
 func deleteManufacturerHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
+		return
+	}
+	before, err := data.GetManufacturer(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	err = data.DeleteManufacturer(db, id)
+	version, err := ifMatch(r, before.Version)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Manufacturer deleted successfully")))
+	err = data.DeleteManufacturer(r.Context(), db, id, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	searchIndex.Invalidate()
+	writeNoContent(w)
 }
 
 func associateManufacturersHandler(w http.ResponseWriter, r *http.Request) {
 	var manufacturers []int
 	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 	if err := json.NewDecoder(r.Body).Decode(&manufacturers); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	err = data.AssociateManufacturers(db, id, manufacturers)
+	err = data.AssociateManufacturers(r.Context(), db, id, manufacturers)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Manufacturer associated successfully")))
+	searchIndex.Invalidate()
+	writeNoContent(w)
 }
 
 func removeAssociatedManufacturersHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 	var manufacturers []int
 	if err := json.NewDecoder(r.Body).Decode(&manufacturers); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	err = data.DeleteAssociationManufacturers(db, id, manufacturers)
+	err = data.DeleteAssociationManufacturers(r.Context(), db, id, manufacturers)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Manufacturers removed successfully")))
+	searchIndex.Invalidate()
+	writeNoContent(w)
 }
 
 func createBikeHandler(w http.ResponseWriter, r *http.Request) {
 	var bike models.Bike
 	if err := json.NewDecoder(r.Body).Decode(&bike); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	framenumber, err := data.CreateBike(db, bike)
+	framenumber, err := data.CreateBike(r.Context(), db, bike)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte(fmt.Sprintf("Bike added successfully - Frame number %s", framenumber)))
+	created, err := data.GetBike(r.Context(), db, framenumber)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(created.BikeVersion))
+	writeCreated(w, r, "/bikes/"+url.PathEscape(framenumber), created)
 }
 
 func getBikeHandler(w http.ResponseWriter, r *http.Request) {
 	framenumber := r.PathValue("framenumber")
-	bike, err := data.GetBike(db, framenumber)
+	bike, err := data.GetBike(r.Context(), db, framenumber)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	j, err := json.Marshal(bike)
+	writeTagged(w, r, http.StatusOK, bike.BikeVersion, bike)
+}
+
+func getBikesHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := listQuery(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
+	bikes, err := data.GetBikes(r.Context(), db, q)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, bikes)
 }
 
-func getBikesHandler(w http.ResponseWriter, r *http.Request) {
-	bikes, err := data.GetBikes(db)
+// patchBikeHandler applies a JSON merge patch to the bike. The product is changed with
+// {"id": 3}, the owner with {"owner": {"id": 5}} and removed with {"owner": null}.
+func patchBikeHandler(w http.ResponseWriter, r *http.Request) {
+	framenumber := r.PathValue("framenumber")
+	before, err := data.GetBike(r.Context(), db, framenumber)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r, before.BikeVersion)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+	var bike models.Bike
+	if err := decodeMergePatch(r, before, &bike); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	j, err := json.Marshal(bikes)
+	bike.FrameNumber, bike.BikeVersion = framenumber, version
+
+	_, err = data.UpdateBike(r.Context(), db, bike)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
+	after, err := data.GetBike(r.Context(), db, framenumber)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTagged(w, r, http.StatusOK, after.BikeVersion, after)
 }
 
 func deleteBikeHandler(w http.ResponseWriter, r *http.Request) {
 	frameNumber := r.PathValue("framenumber")
-	err := data.DeleteBike(db, frameNumber)
+	before, err := data.GetBike(r.Context(), db, frameNumber)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r, before.BikeVersion)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Bike deleted successfully - Frame number %s", frameNumber)))
+	err = data.DeleteBike(r.Context(), db, frameNumber, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeNoContent(w)
 }
 
 func addOwner(w http.ResponseWriter, r *http.Request) {
@@ -458,27 +756,55 @@ func addOwner(w http.ResponseWriter, r *http.Request) {
 	var m map[string]int
 	err := json.NewDecoder(r.Body).Decode(&m)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	owner := m["owner"]
 
-	err = data.AddOwner(db, framenumber, owner)
+	before, err := data.GetBike(r.Context(), db, framenumber)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatchOptional(r, before.BikeVersion)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Owner successfully added - Owner %d | Bike %s", owner, framenumber)))
+	err = data.AddOwner(r.Context(), db, framenumber, owner, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	after, err := data.GetBike(r.Context(), db, framenumber)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTagged(w, r, http.StatusOK, after.BikeVersion, after)
 }
 
 func deleteOwner(w http.ResponseWriter, r *http.Request) {
 	framenumber := r.PathValue("framenumber")
-	err := data.RemoveOwner(db, framenumber)
+	before, err := data.GetBike(r.Context(), db, framenumber)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r, before.BikeVersion)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = data.RemoveOwner(r.Context(), db, framenumber, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	after, err := data.GetBike(r.Context(), db, framenumber)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Owner deleted successfully - Bike : %s", framenumber)))
+	writeTagged(w, r, http.StatusOK, after.BikeVersion, after)
 }
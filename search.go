@@ -0,0 +1,91 @@
+package main
+
+import (
+	"api/data"
+	"api/data/models"
+	"api/search"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchIndex is the product search index. It is invalidated by the handlers that change
+// products, manufacturers or their associations, and rebuilt at least every few minutes.
+var searchIndex = search.NewIndex(loadSearchDocuments, 5*time.Minute)
+
+// Weights of the product fields in the search ranking
+const (
+	nameWeight         = 3
+	manufacturerWeight = 2
+	skuWeight          = 2
+	attributeWeight    = 1
+)
+
+const defaultSearchLimit = 20
+
+func loadSearchDocuments(ctx context.Context) ([]search.Document, error) {
+	products, err := data.GetSearchableProducts(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]search.Document, 0, len(products))
+	for _, p := range products {
+		docs = append(docs, search.Document{Id: p.Id, Fields: []search.Field{
+			{Text: p.Name, Weight: nameWeight},
+			{Text: strings.Join(p.Manufacturers, " "), Weight: manufacturerWeight},
+			{Text: p.Color, Weight: attributeWeight},
+			{Text: p.Size, Weight: attributeWeight},
+			{Text: p.Sku, Weight: skuWeight},
+		}})
+	}
+	return docs, nil
+}
+
+// searchHandler finds products by name, manufacturer, color, size and sku, e.g. /search?q=shimano+red&limit=10
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "the q parameter is required")
+		return
+	}
+	limit := defaultSearchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		limit = min(max(limit, 1), data.MaxLimit)
+	}
+
+	hits, err := searchIndex.Search(r.Context(), query, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	ids := make([]int, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.Id
+	}
+	products, err := data.GetSearchableProductsByIds(r.Context(), db, ids)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	byId := map[int]models.SearchableProduct{}
+	for _, p := range products {
+		byId[p.Id] = p
+	}
+
+	// products deleted since the index was built are left out
+	results := []models.SearchResult{}
+	for _, hit := range hits {
+		if p, ok := byId[hit.Id]; ok {
+			results = append(results, models.SearchResult{SearchableProduct: p, Score: hit.Score})
+		}
+	}
+	writeJSON(w, r, http.StatusOK, results)
+}
@@ -0,0 +1,256 @@
+package search
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Field is a piece of text of a document. Matches in fields with a higher
+// weight rank the document higher.
+type Field struct {
+	Text   string
+	Weight float64
+}
+
+// Document is something that can be found, identified by Id
+type Document struct {
+	Id     int
+	Fields []Field
+}
+
+// Hit is a document matching a query with its score
+type Hit struct {
+	Id    int     `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// Scores given to a query term depending on how it matched a term in the index
+const (
+	exactScore  = 1.0
+	prefixScore = 0.8
+	fuzzyScore  = 0.6
+)
+
+// posting is an occurrence of a term in a document
+type posting struct {
+	doc    int
+	weight float64
+}
+
+// Index is an in-memory inverted index. It is loaded with the documents returned by
+// the load function, and reloaded when it has been invalidated or is older than maxAge.
+type Index struct {
+	load   func(ctx context.Context) ([]Document, error)
+	maxAge time.Duration
+
+	// rebuilding is held while the documents are loaded, so only one search rebuilds the index
+	rebuilding sync.Mutex
+
+	mu       sync.RWMutex
+	postings map[string][]posting
+	terms    []string
+	built    time.Time
+	stale    bool
+	// generation counts the invalidations, so one that arrives while loading is not lost
+	generation uint64
+}
+
+// NewIndex constructs a new Index that is loaded on the first search
+func NewIndex(load func(ctx context.Context) ([]Document, error), maxAge time.Duration) *Index {
+	return &Index{load: load, maxAge: maxAge, stale: true}
+}
+
+// Invalidate makes the next search reload the documents
+func (ix *Index) Invalidate() {
+	ix.mu.Lock()
+	ix.stale = true
+	ix.generation++
+	ix.mu.Unlock()
+}
+
+// Search returns up to limit documents matching the query, best match first.
+// Each word of the query matches terms that are equal to it, start with it, or are
+// within a small edit distance of it, so "shimno derailer" finds "Shimano Derailleur".
+// Documents matching more of the words rank higher.
+func (ix *Index) Search(ctx context.Context, query string, limit int) ([]Hit, error) {
+	if err := ix.refresh(ctx); err != nil {
+		return nil, err
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	words := tokenize(query)
+	scores := map[int]float64{}
+	matched := map[int]int{}
+	for _, word := range words {
+		best := map[int]float64{}
+		for term, termScore := range ix.match(word) {
+			for _, p := range ix.postings[term] {
+				if s := termScore * p.weight; s > best[p.doc] {
+					best[p.doc] = s
+				}
+			}
+		}
+		for doc, s := range best {
+			scores[doc] += s
+			matched[doc]++
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for doc, s := range scores {
+		coverage := float64(matched[doc]) / float64(len(words))
+		hits = append(hits, Hit{Id: doc, Score: s * coverage})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Id < hits[j].Id
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// match finds the terms in the index matching a word of the query and how well they match
+func (ix *Index) match(word string) map[string]float64 {
+	found := map[string]float64{}
+	if _, ok := ix.postings[word]; ok {
+		found[word] = exactScore
+	}
+
+	// terms are sorted, so the terms starting with the word follow each other
+	if len([]rune(word)) >= 2 {
+		i := sort.SearchStrings(ix.terms, word)
+		for ; i < len(ix.terms) && strings.HasPrefix(ix.terms[i], word); i++ {
+			if _, ok := found[ix.terms[i]]; !ok {
+				found[ix.terms[i]] = prefixScore
+			}
+		}
+	}
+
+	maxEdits := allowedEdits(word)
+	if maxEdits == 0 {
+		return found
+	}
+	for _, term := range ix.terms {
+		if _, ok := found[term]; ok {
+			continue
+		}
+		if d := distance(word, term, maxEdits); d <= maxEdits {
+			found[term] = fuzzyScore / float64(d)
+		}
+	}
+	return found
+}
+
+// allowedEdits is the number of typos tolerated in a word, which grows with its length
+func allowedEdits(word string) int {
+	switch n := len([]rune(word)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// fresh reports whether the index can be searched without reloading it, and its generation
+func (ix *Index) fresh() (bool, uint64) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return !ix.stale && time.Since(ix.built) < ix.maxAge, ix.generation
+}
+
+// refresh reloads the index when it is stale. Searches waiting for another one to rebuild
+// the index use its result instead of loading the documents again.
+func (ix *Index) refresh(ctx context.Context) error {
+	if fresh, _ := ix.fresh(); fresh {
+		return nil
+	}
+	ix.rebuilding.Lock()
+	defer ix.rebuilding.Unlock()
+	fresh, generation := ix.fresh()
+	if fresh {
+		return nil
+	}
+
+	docs, err := ix.load(ctx)
+	if err != nil {
+		return err
+	}
+	postings := map[string][]posting{}
+	for _, doc := range docs {
+		weights := map[string]float64{}
+		for _, field := range doc.Fields {
+			for _, term := range tokenize(field.Text) {
+				if field.Weight > weights[term] {
+					weights[term] = field.Weight
+				}
+			}
+		}
+		for term, weight := range weights {
+			postings[term] = append(postings[term], posting{doc: doc.Id, weight: weight})
+		}
+	}
+	terms := make([]string, 0, len(postings))
+	for term := range postings {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+
+	ix.mu.Lock()
+	ix.postings, ix.terms = postings, terms
+	ix.built, ix.stale = time.Now(), ix.generation != generation
+	ix.mu.Unlock()
+	return nil
+}
+
+// tokenize splits text into lower case words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// distance is the Damerau-Levenshtein (optimal string alignment) distance between a and b.
+// It gives up and returns max+1 as soon as the distance is known to exceed max.
+func distance(a string, b string, max int) int {
+	s, t := []rune(a), []rune(b)
+	if d := len(s) - len(t); d > max || -d > max {
+		return max + 1
+	}
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(t)]
+}
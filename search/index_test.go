@@ -0,0 +1,132 @@
+package search
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		max  int
+		want int
+	}{
+		{"shimano", "shimano", 2, 0},
+		{"shimno", "shimano", 2, 1},      // deletion
+		{"shimanoo", "shimano", 2, 1},    // insertion
+		{"shimaso", "shimano", 2, 1},     // substitution
+		{"shimnao", "shimano", 2, 1},     // transposition
+		{"derailer", "derailleur", 2, 2}, // two insertions
+		{"derailer", "derailleur", 1, 2}, // gives up at max+1
+		{"chain", "shimano", 2, 3},       // lengths too far apart
+		{"", "abc", 3, 3},
+		{"sykkel", "sykel", 1, 1},
+		{"grønn", "grøn", 1, 1}, // runes, not bytes
+	}
+	for _, tt := range tests {
+		if got := distance(tt.a, tt.b, tt.max); got != tt.want {
+			t.Errorf("distance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.max, got, tt.want)
+		}
+	}
+}
+
+func catalogue(ctx context.Context) ([]Document, error) {
+	return []Document{
+		{Id: 1, Fields: []Field{{Text: "Shimano Derailleur", Weight: 3}, {Text: "Shimano", Weight: 2}}},
+		{Id: 2, Fields: []Field{{Text: "Shimano Brake Pads", Weight: 3}, {Text: "Shimano", Weight: 2}}},
+		{Id: 3, Fields: []Field{{Text: "SRAM Derailleur Hanger", Weight: 3}, {Text: "SRAM", Weight: 2}}},
+		{Id: 4, Fields: []Field{{Text: "City Helmet", Weight: 3}, {Text: "red", Weight: 1}}},
+	}, nil
+}
+
+func TestSearch(t *testing.T) {
+	ix := NewIndex(catalogue, time.Hour)
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"shimno derailer", []int{1, 2, 3}},
+		{"Shimano Derailleur", []int{1, 2, 3}},
+		{"derail", []int{1, 3}}, // equal scores are ordered by id
+		{"helmt", []int{4}},
+		{"red", []int{4}},
+		{"bottle", []int{}},
+		{"", []int{}},
+	}
+	for _, tt := range tests {
+		hits, err := ix.Search(context.Background(), tt.query, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []int{}
+		for _, hit := range hits {
+			ids = append(ids, hit.Id)
+		}
+		if len(ids) != len(tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, ids, tt.want)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.want[i] {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, ids, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSearchLimit(t *testing.T) {
+	ix := NewIndex(catalogue, time.Hour)
+	hits, err := ix.Search(context.Background(), "shimano", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 {
+		t.Errorf("Search with limit 1 returned %d hits", len(hits))
+	}
+}
+
+// TestInvalidateDuringLoad checks that an invalidation arriving while the index is being
+// loaded makes the next search load it again, and that concurrent searches load it once
+func TestInvalidateDuringLoad(t *testing.T) {
+	var loads atomic.Int32
+	loading := make(chan struct{})
+	release := make(chan struct{})
+	var ix *Index
+	ix = NewIndex(func(ctx context.Context) ([]Document, error) {
+		if loads.Add(1) == 1 {
+			close(loading)
+			<-release
+		}
+		return catalogue(ctx)
+	}, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ix.Search(context.Background(), "helmet", 10); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	<-loading
+	ix.Invalidate()
+	close(release)
+	wg.Wait()
+
+	// the waiting searches see the invalidation and load once more between them
+	if n := loads.Load(); n != 2 {
+		t.Errorf("the index was loaded %d times, want 2", n)
+	}
+	if _, err := ix.Search(context.Background(), "helmet", 10); err != nil {
+		t.Fatal(err)
+	}
+	if n := loads.Load(); n != 2 {
+		t.Errorf("a fresh index was loaded again, %d loads", n)
+	}
+}
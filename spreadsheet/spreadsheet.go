@@ -0,0 +1,68 @@
+package spreadsheet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// zipSignature starts every XLSX file, which is a zip archive
+var zipSignature = []byte("PK\x03\x04")
+
+// Read reads the rows of a CSV file or of the first sheet of an XLSX workbook, which is
+// recognised by its content. The CSV delimiter is a comma, semicolon or tab, whichever
+// occurs most in the first line, since spreadsheets exported with a decimal comma use semicolons.
+func Read(r io.Reader) ([][]string, error) {
+	br := bufio.NewReader(r)
+	start, _ := br.Peek(len(zipSignature))
+	if bytes.Equal(start, zipSignature) {
+		return readXLSX(br)
+	}
+	return readCSV(br)
+}
+
+func readXLSX(r io.Reader) ([][]string, error) {
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, errors.New("the workbook has no sheets")
+	}
+	return f.GetRows(sheets[0])
+}
+
+func readCSV(r *bufio.Reader) ([][]string, error) {
+	// a byte order mark is left by Excel and would end up in the first header
+	if bom, _ := r.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		r.Discard(3)
+	}
+	first, _ := r.Peek(r.Size())
+	if i := bytes.IndexByte(first, '\n'); i >= 0 {
+		first = first[:i]
+	}
+
+	reader := csv.NewReader(r)
+	reader.Comma = delimiter(string(first))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	return reader.ReadAll()
+}
+
+func delimiter(line string) rune {
+	best, count := ',', strings.Count(line, ",")
+	for _, d := range []rune{';', '\t'} {
+		if n := strings.Count(line, string(d)); n > count {
+			best, count = d, n
+		}
+	}
+	return best
+}
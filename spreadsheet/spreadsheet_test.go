@@ -0,0 +1,107 @@
+package spreadsheet
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestReadCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  [][]string
+	}{
+		{
+			name:  "comma",
+			input: "name,price\nHelmet,499\n",
+			want:  [][]string{{"name", "price"}, {"Helmet", "499"}},
+		},
+		{
+			name:  "semicolon with decimal commas",
+			input: "name;price\nHelmet;499,50\n",
+			want:  [][]string{{"name", "price"}, {"Helmet", "499,50"}},
+		},
+		{
+			name:  "tab",
+			input: "name\tprice\tcolor\nHelmet\t499\tred\n",
+			want:  [][]string{{"name", "price", "color"}, {"Helmet", "499", "red"}},
+		},
+		{
+			name:  "more semicolons than commas in the header",
+			input: "name;price;manufacturers\n\"Bell, Spark\";499;Bell\n",
+			want:  [][]string{{"name", "price", "manufacturers"}, {"Bell, Spark", "499", "Bell"}},
+		},
+		{
+			name:  "byte order mark",
+			input: "\xef\xbb\xbfname,price\nHelmet,499\n",
+			want:  [][]string{{"name", "price"}, {"Helmet", "499"}},
+		},
+		{
+			name:  "rows of different lengths and leading spaces",
+			input: "name, price\nHelmet\n",
+			want:  [][]string{{"name", "price"}, {"Helmet"}},
+		},
+		{
+			name:  "no trailing newline",
+			input: "name;price",
+			want:  [][]string{{"name", "price"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Read(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Read() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelimiter(t *testing.T) {
+	tests := []struct {
+		line string
+		want rune
+	}{
+		{"name,price", ','},
+		{"name;price", ';'},
+		{"name\tprice", '\t'},
+		{"name", ','},
+		{"a,b;c;d", ';'},
+		{"a;b,c,d", ','},
+		{"a;b\tc\td", '\t'},
+	}
+	for _, tt := range tests {
+		if got := delimiter(tt.line); got != tt.want {
+			t.Errorf("delimiter(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestReadXLSX(t *testing.T) {
+	f := excelize.NewFile()
+	sheet := f.GetSheetName(0)
+	for cell, value := range map[string]any{"A1": "name", "B1": "price", "A2": "Helmet", "B2": 499} {
+		if err := f.SetCellValue(sheet, cell, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"name", "price"}, {"Helmet", "499"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Read() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"api/data"
+	"api/data/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// Functions for logging in and out
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	var credentials map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	user, err := data.Authenticate(r.Context(), db, credentials["username"], credentials["password"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	session, err := data.CreateSession(r.Context(), db, user.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, session)
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	token, _ := bearerToken(r)
+	err := data.DeleteSession(r.Context(), db, token)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeNoContent(w)
+}
+
+// getMeHandler returns the logged in user, or the API key when called by a machine client
+func getMeHandler(w http.ResponseWriter, r *http.Request) {
+	var me any
+	if apiKey, ok := currentApiKey(r); ok {
+		me = apiKey
+	} else {
+		me, _ = currentUser(r)
+	}
+	writeJSON(w, r, http.StatusOK, me)
+}
+
+// Functions for manipulating staff users
+func createUserHandler(w http.ResponseWriter, r *http.Request) {
+	var user models.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !checkGrantRole(w, r, user.Role) {
+		return
+	}
+	id, err := data.CreateUser(r.Context(), db, user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	user, err = data.GetUser(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeCreated(w, r, fmt.Sprintf("/users/%d", id), user)
+}
+
+func getUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	user, err := data.GetUser(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, user)
+}
+
+func getUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := data.GetUsers(r.Context(), db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, users)
+}
+
+func updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var user models.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// the current role counts as well, or an owner could be demoted by someone with fewer permissions
+	before, err := data.GetUser(r.Context(), db, user.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !checkGrantRole(w, r, before.Role) || !checkGrantRole(w, r, user.Role) {
+		return
+	}
+	err = data.UpdateUser(r.Context(), db, user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	user, err = data.GetUser(r.Context(), db, user.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, user)
+}
+
+// checkGrantRole answers 403 and returns false unless the caller is an owner or holds every
+// permission of the role, so users.manage cannot be used to make someone more powerful than
+// the caller. Unknown roles are left to the data layer to reject.
+func checkGrantRole(w http.ResponseWriter, r *http.Request, role string) bool {
+	if user, ok := currentUser(r); ok && user.Role == data.RoleOwner {
+		return true
+	}
+	roles, err := data.GetRolePermissions(r.Context(), db)
+	if err != nil {
+		writeError(w, r, err)
+		return false
+	}
+	for _, permission := range roles[role] {
+		ok, err := can(r, permission)
+		if err != nil {
+			writeError(w, r, err)
+			return false
+		}
+		if !ok {
+			writeProblem(w, r, http.StatusForbidden, CodeForbidden, "cannot give a role with a permission you do not have: "+permission)
+			return false
+		}
+	}
+	return true
+}
+
+func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = data.DeleteUser(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeNoContent(w)
+}
+
+// Functions for configuring which role may do what
+func getRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := data.GetRolePermissions(r.Context(), db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, roles)
+}
+
+// updateRoleHandler replaces the permissions of a role with the list in the body
+func updateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	role := r.PathValue("role")
+	var permissions []string
+	if err := json.NewDecoder(r.Body).Decode(&permissions); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err := data.SetRolePermissions(r.Context(), db, role, permissions)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	roles, err := data.GetRolePermissions(r.Context(), db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string][]string{role: roles[role]})
+}
+
+// Functions for issuing and revoking API keys for integrations
+func createApiKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var apiKey models.ApiKey
+	if err := json.NewDecoder(r.Body).Decode(&apiKey); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// a key can only be given permissions the caller holds, or apikeys.manage would be enough
+	// to mint a key with every permission. Unknown scopes are left to CreateApiKey to reject.
+	for _, scope := range apiKey.Scopes {
+		if !slices.Contains(data.Permissions, scope) {
+			continue
+		}
+		ok, err := can(r, scope)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !ok {
+			writeProblem(w, r, http.StatusForbidden, CodeForbidden, "cannot grant a permission you do not have: "+scope)
+			return
+		}
+	}
+	apiKey, err := data.CreateApiKey(r.Context(), db, apiKey)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, apiKey)
+}
+
+func getApiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	apiKeys, err := data.GetApiKeys(r.Context(), db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, apiKeys)
+}
+
+func revokeApiKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = data.RevokeApiKey(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeNoContent(w)
+}
@@ -0,0 +1,219 @@
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validate checks a struct against the rules in its `validate` tags and returns
+// Errors with one entry per failing field, or nil if the struct is valid.
+//
+// Rules are separated by commas:
+//
+//	required     the field must not be the zero value
+//	email        a string must be a plain email address
+//	phone        a string must be a phone number
+//	framenumber  a string must be a frame number
+//	gtin         a string, or every string of a slice, must be a GTIN barcode with a valid check digit
+//	date         a string must be a date formatted as YYYY-MM-DD
+//	min=N        a number must be at least N, a string at least N characters
+//	max=N        a number must be at most N, a string at most N characters
+//	-            the field is not validated
+//
+// Nested structs and slices of structs are validated as well, and errors are reported with
+// the JSON path of the field, e.g. "address.city" or "lines[0].quantity". Empty strings are
+// only rejected by required.
+func Validate(v any) error {
+	var errs Errors
+	validateStruct(reflect.Indirect(reflect.ValueOf(v)), "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// FieldError describes a field that failed a rule
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Errors is the list of fields that failed validation
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, f := range e {
+		messages[i] = f.Field + " " + f.Message
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+var (
+	phonePattern       = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{5,19}$`)
+	frameNumberPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]{4,29}$`)
+)
+
+func validateStruct(v reflect.Value, prefix string, errs *Errors) {
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("validate")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		value := v.Field(i)
+		name := prefix + jsonName(field)
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			name = strings.TrimSuffix(prefix, ".")
+		}
+
+		if tag != "" {
+			for _, rule := range strings.Split(tag, ",") {
+				if message := check(rule, value); message != "" {
+					*errs = append(*errs, FieldError{Field: name, Rule: rule, Message: message})
+					break
+				}
+			}
+		}
+		if value.Kind() == reflect.Struct {
+			nested := name + "."
+			if name == "" {
+				nested = ""
+			}
+			validateStruct(value, nested, errs)
+		}
+		if value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Struct {
+			for j := 0; j < value.Len(); j++ {
+				validateStruct(value.Index(j), fmt.Sprintf("%s[%d].", name, j), errs)
+			}
+		}
+	}
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// check returns a message describing why the value breaks the rule, or "" if it does not
+func check(rule string, value reflect.Value) string {
+	name, arg, _ := strings.Cut(rule, "=")
+	switch name {
+	case "required":
+		if value.IsZero() || (value.Kind() == reflect.String && strings.TrimSpace(value.String()) == "") {
+			return "is required"
+		}
+	case "email":
+		s := value.String()
+		if s == "" {
+			return ""
+		}
+		if address, err := mail.ParseAddress(s); err != nil || address.Address != s {
+			return "must be a valid email address"
+		}
+	case "phone":
+		if s := value.String(); s != "" && !phonePattern.MatchString(s) {
+			return "must be a valid phone number"
+		}
+	case "framenumber":
+		if s := value.String(); s != "" && !frameNumberPattern.MatchString(s) {
+			return "must be 5 to 30 letters, digits or dashes"
+		}
+	case "date":
+		if s := value.String(); s != "" {
+			if _, err := time.Parse(time.DateOnly, s); err != nil {
+				return "must be a date formatted as YYYY-MM-DD"
+			}
+		}
+	case "gtin":
+		if value.Kind() == reflect.Slice {
+			for i := 0; i < value.Len(); i++ {
+				if s := value.Index(i).String(); !GTIN(s) {
+					return s + " is not a valid EAN-8, UPC-A, EAN-13 or GTIN-14 barcode"
+				}
+			}
+		} else if s := value.String(); s != "" && !GTIN(s) {
+			return "must be a valid EAN-8, UPC-A, EAN-13 or GTIN-14 barcode"
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validation: invalid rule %q", rule))
+		}
+		return checkBound(name, limit, value)
+	default:
+		panic(fmt.Sprintf("validation: unknown rule %q", rule))
+	}
+	return ""
+}
+
+func checkBound(bound string, limit float64, value reflect.Value) string {
+	// optional values are only checked when they are set
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+	var n float64
+	unit := ""
+	switch value.Kind() {
+	case reflect.String:
+		if value.Len() == 0 {
+			return ""
+		}
+		n = float64(len([]rune(value.String())))
+		unit = " characters"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(value.Int())
+	case reflect.Float32, reflect.Float64:
+		n = value.Float()
+	default:
+		return ""
+	}
+	limitText := strconv.FormatFloat(limit, 'f', -1, 64)
+	if bound == "min" && n < limit {
+		return "must be at least " + limitText + unit
+	}
+	if bound == "max" && n > limit {
+		return "must be at most " + limitText + unit
+	}
+	return ""
+}
+
+// GTIN reports whether code is an EAN-8, UPC-A, EAN-13 or GTIN-14 barcode number with
+// a correct check digit. The check digit is the last digit, and makes the sum of the digits,
+// weighted 3 and 1 alternately from the right, a multiple of 10.
+func GTIN(code string) bool {
+	switch len(code) {
+	case 8, 12, 13, 14:
+	default:
+		return false
+	}
+	sum := 0
+	for i := len(code) - 1; i >= 0; i-- {
+		c := code[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if (len(code)-1-i)%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,103 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestGTIN(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"96385074", true},         // EAN-8
+		{"036000291452", true},     // UPC-A
+		{"4006381333931", true},    // EAN-13
+		{"10012345678902", true},   // GTIN-14
+		{"00012345600012", true},   // GTIN-14 with leading zeros
+		{"4006381333932", false},   // wrong check digit
+		{"400638133393", false},    // 12 digits, wrong check digit
+		{"40063813339", false},     // 11 digits
+		{"400638133393A", false},   // not a digit
+		{"", false},                // empty
+		{"100123456789021", false}, // 15 digits
+	}
+	for _, tt := range tests {
+		if got := GTIN(tt.code); got != tt.want {
+			t.Errorf("GTIN(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	price := float32(-1)
+	tests := []struct {
+		rule  string
+		value any
+		want  string
+	}{
+		{"required", "", "is required"},
+		{"required", "   ", "is required"},
+		{"required", 0, "is required"},
+		{"required", "Helmet", ""},
+		{"email", "", ""},
+		{"email", "kari@example.com", ""},
+		{"email", "Kari <kari@example.com>", "must be a valid email address"},
+		{"email", "kari", "must be a valid email address"},
+		{"phone", "+47 123 45 678", ""},
+		{"phone", "call me", "must be a valid phone number"},
+		{"framenumber", "WTU123-4567", ""},
+		{"framenumber", "W12", "must be 5 to 30 letters, digits or dashes"},
+		{"date", "2024-05-01", ""},
+		{"date", "01.05.2024", "must be a date formatted as YYYY-MM-DD"},
+		{"gtin", "", ""},
+		{"gtin", "4006381333931", ""},
+		{"gtin", "4006381333932", "must be a valid EAN-8, UPC-A, EAN-13 or GTIN-14 barcode"},
+		{"gtin", []string{"96385074", "123"}, "123 is not a valid EAN-8, UPC-A, EAN-13 or GTIN-14 barcode"},
+		{"min=0", -1, "must be at least 0"},
+		{"min=0", &price, "must be at least 0"},
+		{"min=0", (*float32)(nil), ""},
+		{"max=5", "abcdef", "must be at most 5 characters"},
+		{"max=5", "", ""},
+		{"max=1000000", 1000000.5, "must be at most 1000000"},
+	}
+	for _, tt := range tests {
+		if got := check(tt.rule, reflect.ValueOf(tt.value)); got != tt.want {
+			t.Errorf("check(%q, %#v) = %q, want %q", tt.rule, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidateReportsJSONPaths(t *testing.T) {
+	type address struct {
+		City string `json:"city" validate:"max=3"`
+	}
+	type line struct {
+		Quantity int `json:"quantity" validate:"min=1"`
+	}
+	type order struct {
+		Name    string  `json:"name" validate:"required"`
+		Address address `json:"address"`
+		Lines   []line  `json:"lines"`
+		Skipped string  `json:"skipped" validate:"-"`
+	}
+
+	err := Validate(order{Address: address{City: "Oslo"}, Lines: []line{{Quantity: 1}, {Quantity: 0}}})
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Validate() = %v, want Errors", err)
+	}
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	want := []string{"name", "address.city", "lines[1].quantity"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields = %v, want %v", fields, want)
+	}
+
+	if err := Validate(order{Name: "Order", Lines: []line{{Quantity: 2}}}); err != nil {
+		t.Errorf("Validate() of a valid order = %v, want nil", err)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"api/data"
+	"api/data/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Functions for manipulating the variants of a product
+
+// variantPath reads the product id and variant id of /products/{id}/variants/{variantId}
+func variantPath(r *http.Request) (int, int, error) {
+	parent, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return -1, -1, err
+	}
+	id, err := strconv.Atoi(r.PathValue("variantId"))
+	if err != nil {
+		return -1, -1, err
+	}
+	return parent, id, nil
+}
+
+func createVariantHandler(w http.ResponseWriter, r *http.Request) {
+	parent, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var variant models.Variant
+	if err := json.NewDecoder(r.Body).Decode(&variant); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if variant.Price != nil {
+		ok, err := can(r, data.PermProductsPrice)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !ok {
+			writeProblem(w, r, http.StatusForbidden, CodeForbidden, "missing permission: "+data.PermProductsPrice)
+			return
+		}
+	}
+	variant.ParentId = parent
+
+	id, err := data.CreateVariant(r.Context(), db, variant)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	created, err := data.GetVariant(r.Context(), db, parent, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	searchIndex.Invalidate()
+	w.Header().Set("ETag", etag(created.Version))
+	writeCreated(w, r, fmt.Sprintf("/products/%d/variants/%d", parent, id), created)
+}
+
+func getVariantHandler(w http.ResponseWriter, r *http.Request) {
+	parent, id, err := variantPath(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	variant, err := data.GetVariant(r.Context(), db, parent, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTagged(w, r, http.StatusOK, variant.Version, variant)
+}
+
+func getVariantsHandler(w http.ResponseWriter, r *http.Request) {
+	parent, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	variants, err := data.GetVariants(r.Context(), db, parent)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, variants)
+}
+
+func updateVariantHandler(w http.ResponseWriter, r *http.Request) {
+	parent, id, err := variantPath(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var variant models.Variant
+	if err := json.NewDecoder(r.Body).Decode(&variant); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before, err := data.GetVariant(r.Context(), db, parent, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !samePrice(before.Price, variant.Price) {
+		ok, err := can(r, data.PermProductsPrice)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !ok {
+			writeProblem(w, r, http.StatusForbidden, CodeForbidden, "missing permission: "+data.PermProductsPrice)
+			return
+		}
+	}
+	variant.Id, variant.ParentId = id, parent
+	variant.Version, err = ifMatch(r, before.Version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = data.UpdateVariant(r.Context(), db, variant)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	after, err := data.GetVariant(r.Context(), db, parent, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	searchIndex.Invalidate()
+	writeTagged(w, r, http.StatusOK, after.Version, after)
+}
+
+func deleteVariantHandler(w http.ResponseWriter, r *http.Request) {
+	parent, id, err := variantPath(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before, err := data.GetVariant(r.Context(), db, parent, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r, before.Version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = data.DeleteVariant(r.Context(), db, parent, id, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	searchIndex.Invalidate()
+	writeNoContent(w)
+}
+
+// samePrice reports whether two optional price overrides are equal
+func samePrice(a *float32, b *float32) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"api/data"
+	"api/data/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Functions for manipulating workcards
+func createWorkcardHandler(w http.ResponseWriter, r *http.Request) {
+	var workcard models.Workcard
+	if err := json.NewDecoder(r.Body).Decode(&workcard); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	id, err := data.CreateWorkcard(r.Context(), db, workcard)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	workcard, err = data.GetWorkcard(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeCreated(w, r, fmt.Sprintf("/workcards/%d", id), workcard)
+}
+
+func getWorkcardHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	workcard, err := data.GetWorkcard(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, workcard)
+}
+
+func getWorkcardsHandler(w http.ResponseWriter, r *http.Request) {
+	workcards, err := data.GetWorkcards(r.Context(), db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, workcards)
+}
+
+func updateWorkcardHandler(w http.ResponseWriter, r *http.Request) {
+	var workcard models.Workcard
+	if err := json.NewDecoder(r.Body).Decode(&workcard); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err := data.UpdateWorkcard(r.Context(), db, workcard)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	workcard, err = data.GetWorkcard(r.Context(), db, workcard.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, workcard)
+}
+
+// Functions for tracking the time mechanics spend on workcards
+
+// clockInHandler clocks the mechanic in the body ({"mechanicId": 1}) in on the workcard
+func clockInHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var body map[string]int
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	entry, err := data.ClockIn(r.Context(), db, id, body["mechanicId"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeCreated(w, r, fmt.Sprintf("/workcards/%d/time", id), entry)
+}
+
+// clockOutHandler clocks the mechanic in the body ({"mechanicId": 1}) out of the workcard
+func clockOutHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var body map[string]int
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	entry, err := data.ClockOut(r.Context(), db, id, body["mechanicId"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, entry)
+}
+
+func getWorkcardTimeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	workcardTime, err := data.GetWorkcardTime(r.Context(), db, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, workcardTime)
+}
+
+// getProductivityHandler reports the time logged per mechanic from ?from= until and including ?to= (YYYY-MM-DD)
+func getProductivityHandler(w http.ResponseWriter, r *http.Request) {
+	from, err := time.ParseInLocation(time.DateOnly, r.URL.Query().Get("from"), time.Local)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	to, err := time.ParseInLocation(time.DateOnly, r.URL.Query().Get("to"), time.Local)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	report, err := data.GetProductivity(r.Context(), db, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, report)
+}